@@ -14,8 +14,29 @@ func parseFlags() relay.Config {
 	var config relay.Config
 
 	flag.BoolVar(&logger.Debug, "debug", false, "enable debug mode")
+	flag.StringVar(&config.MouseInput, "mouse-input", "", "mouse input device, a /dev/input/by-id or by-path symlink or a glob pattern (default: detect mice and touchpads)")
+	flag.StringVar(&config.KeyboardInput, "keyboard-input", "", "keyboard input device, a /dev/input/by-id or by-path symlink or a glob pattern (default: detect keyboards)")
 	flag.StringVar(&config.MouseOutput, "mouse-output", "/dev/hidg0", "mouse output device")
 	flag.StringVar(&config.KeyboardOutput, "keyboard-output", "/dev/hidg1", "keyboard output device")
+	flag.StringVar(&config.ConsumerOutput, "consumer-output", "/dev/hidg2", "consumer control (media keys) output device, empty to disable")
+	flag.StringVar(&config.DigitizerOutput, "digitizer-output", "/dev/hidg3", "digitizer (pen tablet, touchscreen) output device, empty to disable")
+	flag.StringVar(&config.GamepadOutput, "gamepad-output", "/dev/hidg4", "gamepad output device, empty to disable (gadget must be set up with GAMEPAD=on)")
+	flag.Float64Var(&config.Pointer.Sensitivity, "pointer-sensitivity", 1.0, "pointer speed multiplier")
+	flag.StringVar(&config.Pointer.Acceleration, "pointer-accel", relay.AccelFlat, "pointer acceleration profile: flat, adaptive or curve")
+	flag.StringVar(&config.Pointer.Curve, "pointer-curve", "", "acceleration curve for -pointer-accel=curve as speed:factor pairs, speed in counts per ms (e.g. 0:1,1:1.5,4:3)")
+	flag.BoolVar(&config.SystemControl, "system-control", true, "relay power, sleep and wake keys to the host (device rules with system=on|off override this per device)")
+	flag.BoolVar(&config.GrabInput, "grab", false, "grab relayed input devices exclusively, so the board's console doesn't see their input")
+	flag.BoolVar(&config.KeyboardNKRO, "keyboard-nkro", false, "send N-key rollover keyboard reports (gadget must be set up with KEYBOARD_MODE=nkro)")
+	flag.BoolVar(&config.Mouse16Bit, "mouse-16bit", false, "send 16-bit mouse motion (gadget must be set up with MOUSE_MODE=16bit)")
+	flag.BoolVar(&config.MouseHiRes, "mouse-hires-wheel", false, "send high-resolution scrolling when the host enables it (gadget must be set up with MOUSE_WHEEL=hires)")
+	flag.Func("device", "device rule deciding which input devices are relayed, e.g. 'deny name=\"Power Button\"' (repeatable, the first matching rule applies)", func(s string) error {
+		rule, err := device.ParseRule(s)
+		if err != nil {
+			return err
+		}
+		config.DeviceRules = append(config.DeviceRules, rule)
+		return nil
+	})
 
 	if !flag.Parsed() {
 		flag.Parse()
@@ -41,6 +62,7 @@ func main() {
 	}
 
 	relay := relay.NewRelay(config)
+	go logDeviceStates(relay.States())
 	if err := relay.Start(); err != nil {
 		log.Printf("Error: %v", err)
 		os.Exit(1)
@@ -48,3 +70,14 @@ func main() {
 
 	log.Println("Relay stopped successfully")
 }
+
+// logDeviceStates logs the state changes of the relayed input devices.
+func logDeviceStates(states <-chan relay.DeviceStateChange) {
+	for change := range states {
+		if change.Path == "" {
+			log.Printf("%s: %s", change.Device, change.State)
+		} else {
+			log.Printf("%s %s: %s", change.Device, change.Path, change.State)
+		}
+	}
+}
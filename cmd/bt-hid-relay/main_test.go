@@ -50,6 +50,8 @@ func TestParseFlags(t *testing.T) {
 		args     []string
 		wantConf struct {
 			debug          bool
+			mouseInput     string
+			keyboardInput  string
 			mouseOutput    string
 			keyboardOutput string
 		}
@@ -59,6 +61,8 @@ func TestParseFlags(t *testing.T) {
 			args: []string{"cmd"},
 			wantConf: struct {
 				debug          bool
+				mouseInput     string
+				keyboardInput  string
 				mouseOutput    string
 				keyboardOutput string
 			}{
@@ -72,15 +76,21 @@ func TestParseFlags(t *testing.T) {
 			args: []string{
 				"cmd",
 				"-debug",
+				"-mouse-input=/dev/input/by-id/*MX_Master*-event-mouse",
+				"-keyboard-input=/dev/input/by-path/platform-keyboard-event-kbd",
 				"-mouse-output=/dev/custom0",
 				"-keyboard-output=/dev/custom1",
 			},
 			wantConf: struct {
 				debug          bool
+				mouseInput     string
+				keyboardInput  string
 				mouseOutput    string
 				keyboardOutput string
 			}{
 				debug:          true,
+				mouseInput:     "/dev/input/by-id/*MX_Master*-event-mouse",
+				keyboardInput:  "/dev/input/by-path/platform-keyboard-event-kbd",
 				mouseOutput:    "/dev/custom0",
 				keyboardOutput: "/dev/custom1",
 			},
@@ -98,6 +108,12 @@ func TestParseFlags(t *testing.T) {
 			if logger.Debug != tt.wantConf.debug {
 				t.Errorf("parseFlags() debug = %v, want %v", logger.Debug, tt.wantConf.debug)
 			}
+			if got.MouseInput != tt.wantConf.mouseInput {
+				t.Errorf("parseFlags() mouseInput = %v, want %v", got.MouseInput, tt.wantConf.mouseInput)
+			}
+			if got.KeyboardInput != tt.wantConf.keyboardInput {
+				t.Errorf("parseFlags() keyboardInput = %v, want %v", got.KeyboardInput, tt.wantConf.keyboardInput)
+			}
 			if got.MouseOutput != tt.wantConf.mouseOutput {
 				t.Errorf("parseFlags() mouseOutput = %v, want %v", got.MouseOutput, tt.wantConf.mouseOutput)
 			}
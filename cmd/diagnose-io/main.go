@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/device"
@@ -66,6 +67,11 @@ func verifyDevices() error {
 	fmt.Println("\nChecking HID gadget devices:")
 	checkDevice("/dev/hidg0", "Mouse HID gadget")
 	checkDevice("/dev/hidg1", "Keyboard HID gadget")
+	checkDevice("/dev/hidg2", "Consumer control HID gadget")
+	checkDevice("/dev/hidg3", "Digitizer HID gadget")
+	checkDevice("/dev/hidg4", "Gamepad HID gadget")
+
+	listInputDevices()
 
 	// Check input devices
 	fmt.Println("\nChecking input devices:")
@@ -84,9 +90,57 @@ func verifyDevices() error {
 		fmt.Printf("%s Keyboard input device: %s\n", checkMark, keyboard)
 	}
 
+	digitizer, err := device.FindDigitizer()
+	if err != nil {
+		fmt.Printf("%s Digitizer input device: not found\n", crossMark)
+	} else {
+		fmt.Printf("%s Digitizer input device: %s\n", checkMark, digitizer)
+	}
+
+	gamepad, err := device.FindGamepad()
+	if err != nil {
+		fmt.Printf("%s Gamepad input device: not found\n", crossMark)
+	} else {
+		fmt.Printf("%s Gamepad input device: %s\n", checkMark, gamepad)
+	}
+
 	return nil
 }
 
+// listInputDevices prints every input device the kernel knows about along
+// with the types the relay recognizes it as, to show why a device is or
+// isn't picked up.
+func listInputDevices() {
+	fmt.Println("\nInput devices:")
+
+	devices, err := device.ListInputDevices()
+	if err != nil {
+		fmt.Printf("%s Failed to list input devices: %v\n", crossMark, err)
+		return
+	}
+
+	for _, dev := range devices {
+		path := dev.EventPath()
+		if path == "" {
+			path = "(no event device)"
+		}
+
+		types := strings.Join(device.Classify(dev), ", ")
+		if types == "" {
+			types = "not relayed"
+		}
+
+		fmt.Printf("  %s: %q [%s] bus %04x, id %04x:%04x", path, dev.Name, types, dev.Bus, dev.Vendor, dev.Product)
+		if dev.Uniq != "" {
+			fmt.Printf(", uniq %s", dev.Uniq)
+		}
+		if dev.Phys != "" {
+			fmt.Printf(", phys %s", dev.Phys)
+		}
+		fmt.Println()
+	}
+}
+
 func checkDevice(path, description string) {
 	if _, err := osStat(path); os.IsNotExist(err) {
 		fmt.Printf("%s %s: not found (%s)\n", crossMark, description, path)
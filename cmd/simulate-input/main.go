@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -15,6 +16,13 @@ const (
 var (
 	osOpenFile = os.OpenFile
 	delay      = 300 * time.Millisecond
+
+	// mouse16Bit selects the report layout of a gadget set up with MOUSE_MODE=16bit.
+	// MOUSE_WHEEL doesn't change the layout of the reports written here.
+	mouse16Bit bool
+
+	// keyboardNKRO selects the report layout of a gadget set up with KEYBOARD_MODE=nkro.
+	keyboardNKRO bool
 )
 
 var openDevice = func(path string) (FileWriter, error) {
@@ -27,6 +35,10 @@ type FileWriter interface {
 }
 
 func main() {
+	flag.BoolVar(&mouse16Bit, "mouse-16bit", os.Getenv("MOUSE_MODE") == "16bit", "write 16-bit mouse reports (gadget set up with MOUSE_MODE=16bit)")
+	flag.BoolVar(&keyboardNKRO, "keyboard-nkro", os.Getenv("KEYBOARD_MODE") == "nkro", "write NKRO keyboard reports (gadget set up with KEYBOARD_MODE=nkro)")
+	flag.Parse()
+
 	for {
 		fmt.Println("\nBluetooth HID Simulator")
 		fmt.Println("======================")
@@ -65,21 +77,26 @@ func moveMouseInCircle() {
 
 	fmt.Println("Moving mouse in a circle...")
 
-	report := []byte{0, 40, 0, 0} // Move right
-	f.Write(report)
+	f.Write(mouseReport(40, 0)) // Move right
 	time.Sleep(delay)
 
-	report = []byte{0, 0, 40, 0} // Move down
-	f.Write(report)
+	f.Write(mouseReport(0, 40)) // Move down
 	time.Sleep(delay)
 
-	report = []byte{0, 216, 0, 0} // Move left  (-40 as byte = 256-40 = 216)
-	f.Write(report)
+	f.Write(mouseReport(-40, 0)) // Move left
 	time.Sleep(delay)
 
-	// Move up
-	report = []byte{0, 0, 216, 0} // Move up    (-40 as byte = 256-40 = 216)
-	f.Write(report)
+	f.Write(mouseReport(0, -40)) // Move up
+}
+
+// mouseReport builds a motion report in the relay's layout: buttons, X, Y,
+// wheel and horizontal wheel, with X and Y as 16-bit little-endian values in
+// 16-bit mode.
+func mouseReport(dx, dy int16) []byte {
+	if mouse16Bit {
+		return []byte{0, byte(dx), byte(dx >> 8), byte(dy), byte(dy >> 8), 0, 0}
+	}
+	return []byte{0, byte(dx), byte(dy), 0, 0}
 }
 
 func typeTestMessage() {
@@ -108,14 +125,27 @@ func typeTestMessage() {
 	for _, char := range message {
 		if hidCode, exists := asciiToHID[char]; exists {
 			// Send keypress
-			report := make([]byte, 8)
-			report[2] = hidCode
-			f.Write(report)
+			f.Write(keyboardReport(hidCode))
 
 			// Release key
-			f.Write(make([]byte, 8))
+			f.Write(keyboardReport(0))
 
 			time.Sleep(delay)
 		}
 	}
 }
+
+// keyboardReport builds a report with the given key pressed, or none for 0.
+// In NKRO mode the 8-byte boot report is followed by a bitmap with one bit
+// per usage from 0x00 to 0xDF, matching the relay's layout.
+func keyboardReport(hidCode byte) []byte {
+	if !keyboardNKRO {
+		return []byte{0, 0, hidCode, 0, 0, 0, 0, 0}
+	}
+	report := make([]byte, 36)
+	if hidCode != 0 {
+		report[2] = hidCode
+		report[8+hidCode/8] |= 1 << (hidCode % 8)
+	}
+	return report
+}
@@ -70,10 +70,10 @@ func TestMoveMouseInCircle(t *testing.T) {
 	// Verify writes
 	got := mock.Bytes()
 	expected := []byte{
-		0, 40, 0, 0, // Right
-		0, 0, 40, 0, // Down
-		0, 216, 0, 0, // Left
-		0, 0, 216, 0, // Up
+		0, 40, 0, 0, 0, // Right
+		0, 0, 40, 0, 0, // Down
+		0, 216, 0, 0, 0, // Left
+		0, 0, 216, 0, 0, // Up
 	}
 
 	if !bytes.Equal(got, expected) {
@@ -128,3 +128,43 @@ func TestTypeTestMessage(t *testing.T) {
 		t.Errorf("Unexpected data length: %d", len(got))
 	}
 }
+
+func TestMouseReport(t *testing.T) {
+	original := mouse16Bit
+	defer func() {
+		mouse16Bit = original
+	}()
+
+	mouse16Bit = false
+	if got, expected := mouseReport(-40, 0), []byte{0, 216, 0, 0, 0}; !bytes.Equal(got, expected) {
+		t.Errorf("8-bit report: expected %v, got %v", expected, got)
+	}
+
+	mouse16Bit = true
+	if got, expected := mouseReport(-40, 300), []byte{0, 216, 255, 44, 1, 0, 0}; !bytes.Equal(got, expected) {
+		t.Errorf("16-bit report: expected %v, got %v", expected, got)
+	}
+}
+
+func TestKeyboardReport(t *testing.T) {
+	original := keyboardNKRO
+	defer func() {
+		keyboardNKRO = original
+	}()
+
+	keyboardNKRO = false
+	if got, expected := keyboardReport(0x17), []byte{0, 0, 0x17, 0, 0, 0, 0, 0}; !bytes.Equal(got, expected) {
+		t.Errorf("boot report: expected %v, got %v", expected, got)
+	}
+
+	keyboardNKRO = true
+	expected := make([]byte, 36)
+	expected[2] = 0x17
+	expected[8+2] = 1 << 7
+	if got := keyboardReport(0x17); !bytes.Equal(got, expected) {
+		t.Errorf("NKRO report: expected %v, got %v", expected, got)
+	}
+	if got := keyboardReport(0); !bytes.Equal(got, make([]byte, 36)) {
+		t.Errorf("NKRO release: expected an empty report, got %v", got)
+	}
+}
@@ -3,6 +3,7 @@ package device
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -32,33 +33,142 @@ type DeviceConfig struct {
 var FindInputDeviceFunc = FindInputDevice
 var readFile = os.ReadFile
 
+// Device types FindInputDevice recognizes by their capabilities
+var deviceClassifiers = map[string]Filter{
+	"keyboard":  IsKeyboard,
+	"mouse":     IsMouse,
+	"touchpad":  IsTouchpad,
+	"digitizer": IsDigitizer,
+	"gamepad":   IsGamepad,
+}
+
+// FindInputDevice finds the event device of an input device of the given
+// type. Keyboards, mice and the other known types are recognized by what
+// they can do rather than by their name, which often says nothing about the
+// device ("MX Master 3", "K380"). Any other type is looked up in the device
+// names.
 func FindInputDevice(deviceType string) (string, error) {
-	data, err := readFile("/proc/bus/input/devices")
+	paths, err := FindInputDevicePaths(deviceType, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", fmt.Errorf("%s not found", deviceType)
+	}
+	return paths[0], nil
+}
+
+// FindInputDevicePaths returns the event devices of all input devices of the
+// given type, recognized like in FindInputDevice, that the rules let through.
+func FindInputDevicePaths(deviceType string, rules Rules) ([]string, error) {
+	devices, err := FindInputDevices(rules.Filter(deviceType))
 	if err != nil {
-		return "", fmt.Errorf("failed to read devices: %v", err)
+		return nil, err
 	}
 
-	var deviceName string
+	var paths []string
+	for _, dev := range devices {
+		if path := dev.EventPath(); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
 
-	// Look through each line
-	for _, line := range strings.Split(string(data), "\n") {
-		// Look for device name
-		if strings.HasPrefix(line, "N: Name=") {
-			if strings.Contains(strings.ToLower(line), deviceType) {
-				deviceName = line
-			}
+// RelayedDevice is an event device along with the types it is relayed as.
+type RelayedDevice struct {
+	Path  string
+	Types []string
+}
+
+// FindRelayedDevices returns the event devices that the rules let through as
+// any of the given types, along with the types each one is relayed as. A
+// device relayed as several types appears once, so it can be read by a
+// single stream.
+func FindRelayedDevices(deviceTypes []string, rules Rules) ([]RelayedDevice, error) {
+	devices, err := ListInputDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var relayed []RelayedDevice
+	for _, dev := range devices {
+		path := dev.EventPath()
+		if path == "" {
 			continue
 		}
+		if types := rules.Types(dev, deviceTypes); len(types) > 0 {
+			relayed = append(relayed, RelayedDevice{Path: path, Types: types})
+		}
+	}
+	return relayed, nil
+}
 
-		// If we found a matching device name, look for its event
-		if deviceName != "" && strings.HasPrefix(line, "H: Handlers=") {
-			for _, word := range strings.Fields(line) {
-				if strings.HasPrefix(word, "event") {
-					return fmt.Sprintf("/dev/input/%s", word), nil
-				}
-			}
+// ResolveInputPaths returns the event devices a path refers to. The path can
+// name an event device, a symlink such as those under /dev/input/by-id and
+// /dev/input/by-path, or be a glob pattern matching several of them.
+// Symlinks are resolved on each call, so they keep following a device that
+// comes back under a different event number. Other input nodes, like the
+// /dev/input/mouseN devices the legacy "-mouse" links point to, are left
+// out, and a pattern matching nothing else is an error.
+func ResolveInputPaths(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input device pattern %q: %v", pattern, err)
+	}
+
+	var paths, others []string
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		path, err := filepath.EvalSymlinks(match)
+		if err != nil {
+			continue // Dangling link of a device that just went away
+		}
+		if !strings.HasPrefix(filepath.Base(path), "event") {
+			others = append(others, path)
+			continue
+		}
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
 		}
 	}
 
-	return "", fmt.Errorf("%s not found", deviceType)
+	if len(paths) == 0 && len(others) > 0 {
+		return nil, fmt.Errorf("input device pattern %q matches no event device, only %s", pattern, strings.Join(others, ", "))
+	}
+	return paths, nil
+}
+
+// FindDigitizer finds an absolute pointing device: a pen tablet, a
+// touchscreen or a mouse reporting absolute coordinates.
+func FindDigitizer() (string, error) {
+	return FindInputDevice("digitizer")
+}
+
+// FindTouchpad finds a multitouch touchpad, standalone or built into a
+// keyboard.
+func FindTouchpad() (string, error) {
+	return FindInputDevice("touchpad")
+}
+
+// FindGamepad finds a game controller: a gamepad or another joystick.
+func FindGamepad() (string, error) {
+	return FindInputDevice("gamepad")
+}
+
+// Device types in the order they are classified and relayed
+var deviceTypes = []string{"keyboard", "mouse", "touchpad", "digitizer", "gamepad"}
+
+// Classify returns the types a device is relayed as without device rules.
+func Classify(dev InputDevice) []string {
+	return Rules(nil).Types(dev, deviceTypes)
+}
+
+// NameContains matches devices whose name contains s, ignoring case.
+func NameContains(s string) Filter {
+	s = strings.ToLower(s)
+	return func(dev InputDevice) bool {
+		return strings.Contains(strings.ToLower(dev.Name), s)
+	}
 }
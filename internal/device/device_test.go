@@ -2,6 +2,8 @@ package device
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -27,7 +29,10 @@ S: Sysfs=/devices/pci0000:00/0000:00:14.0/usb1/1-2/1-2:1.0/0003:04D9:0024.0002/i
 U: Uniq=
 H: Handlers=sysrq kbd event5 leds
 B: PROP=0
-B: EV=120013`
+B: EV=120013
+B: KEY=1000000000007 ff9f207ac14057ff febeffdfffefffff fffffffffffffffe
+B: MSC=10
+B: LED=7`
 
 	tmpfile, err := os.CreateTemp("", "devices")
 	if err != nil {
@@ -96,3 +101,247 @@ B: EV=120013`
 		})
 	}
 }
+
+func TestFindByCapabilities(t *testing.T) {
+	touchpad := `I: Bus=0005 Vendor=05ac Product=0265 Version=0110
+N: Name="Magic Trackpad 2"
+H: Handlers=mouse1 event6
+B: PROP=5
+B: EV=1b
+B: KEY=e520 10000 0 0 0 0
+B: ABS=2e0800000000003
+
+`
+	pen := `I: Bus=0005 Vendor=056a Product=0378 Version=0110
+N: Name="Wacom Intuos BT M Pen"
+H: Handlers=mouse2 event7
+B: PROP=1
+B: EV=1b
+B: KEY=1c03 0 0 0 0 0
+B: ABS=1000003
+`
+	touchscreen := `I: Bus=0003 Vendor=0eef Product=0001 Version=0100
+N: Name="eGalax Inc. USB TouchController"
+H: Handlers=mouse3 event8
+B: PROP=2
+B: EV=b
+B: KEY=400 0 0 0 0 0
+B: ABS=3
+`
+	gamepad := `I: Bus=0005 Vendor=045e Product=0b13 Version=0509
+N: Name="Xbox Wireless Controller"
+H: Handlers=event9 js0
+B: PROP=0
+B: EV=20001b
+B: KEY=7fdb000000000000 0 0 0 0
+B: ABS=3003f
+`
+
+	tests := []struct {
+		name    string
+		find    func() (string, error)
+		devices string
+		want    string
+		wantErr bool
+	}{
+		{name: "Pen tablet", find: FindDigitizer, devices: touchpad + pen, want: "/dev/input/event7"},
+		{name: "Touchscreen", find: FindDigitizer, devices: touchpad + touchscreen, want: "/dev/input/event8"},
+		{name: "Touchpad is no digitizer", find: FindDigitizer, devices: touchpad, wantErr: true},
+		{name: "Touchpad", find: FindTouchpad, devices: pen + "\n" + touchpad, want: "/dev/input/event6"},
+		{name: "Touchscreen is no touchpad", find: FindTouchpad, devices: touchscreen, wantErr: true},
+		{name: "Gamepad", find: FindGamepad, devices: touchpad + gamepad, want: "/dev/input/event9"},
+		{name: "Gamepad is no digitizer", find: FindDigitizer, devices: gamepad, wantErr: true},
+		{name: "Pen is no gamepad", find: FindGamepad, devices: pen, wantErr: true},
+	}
+
+	originalReadFile := readFile
+	defer func() {
+		readFile = originalReadFile
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readFile = func(name string) ([]byte, error) {
+				return []byte(tt.devices), nil
+			}
+
+			got, err := tt.find()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("find() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("find() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindInputDevice_Capabilities(t *testing.T) {
+	devices := `I: Bus=0005 Vendor=046d Product=b35f Version=0011
+N: Name="K380 Consumer Control"
+H: Handlers=kbd event2
+B: PROP=0
+B: EV=1f
+B: KEY=3007f 0 0 483ffff17aff32d bfd4444600000000 1 130c730b17c000 267bfad9415fed 9e168000004400 10000002
+B: REL=1040
+B: ABS=100000000
+B: MSC=10
+
+I: Bus=0005 Vendor=046d Product=b35f Version=0011
+N: Name="Keyboard K380"
+H: Handlers=sysrq kbd leds event3
+B: PROP=0
+B: EV=12001f
+B: KEY=3f000303ff 0 0 483ffff17aff32d bfd4444600000000 ffff0001 130ff38b17c007 ffff7bfad9415fff ffbeffdf7ffff fffffffffffffffe
+B: REL=1040
+B: ABS=100000000
+B: MSC=10
+B: LED=1f
+
+I: Bus=0005 Vendor=046d Product=b023 Version=0016
+N: Name="MX Master 3"
+H: Handlers=event4 mouse0
+B: PROP=0
+B: EV=17
+B: KEY=ffff0000 0 0 0 0
+B: REL=1943
+B: MSC=10
+`
+
+	originalReadFile := readFile
+	readFile = func(name string) ([]byte, error) {
+		return []byte(devices), nil
+	}
+	defer func() {
+		readFile = originalReadFile
+	}()
+
+	tests := []struct {
+		deviceType string
+		want       string
+	}{
+		{deviceType: "keyboard", want: "/dev/input/event3"},
+		{deviceType: "mouse", want: "/dev/input/event4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.deviceType, func(t *testing.T) {
+			got, err := FindInputDevice(tt.deviceType)
+			if err != nil {
+				t.Fatalf("FindInputDevice() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("FindInputDevice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindRelayedDevices(t *testing.T) {
+	devices := `I: Bus=0005 Vendor=046d Product=b35f Version=0011
+N: Name="K380 Consumer Control"
+H: Handlers=kbd event2
+B: PROP=0
+B: EV=1f
+B: KEY=3007f 0 0 483ffff17aff32d bfd4444600000000 1 130c730b17c000 267bfad9415fed 9e168000004400 10000002
+B: REL=1040
+B: ABS=100000000
+B: MSC=10
+
+I: Bus=0005 Vendor=046d Product=b35f Version=0011
+N: Name="Keyboard K380"
+H: Handlers=sysrq kbd leds event3
+B: PROP=0
+B: EV=12001f
+B: KEY=3f000303ff 0 0 483ffff17aff32d bfd4444600000000 ffff0001 130ff38b17c007 ffff7bfad9415fff ffbeffdf7ffff fffffffffffffffe
+B: REL=1040
+B: ABS=100000000
+B: MSC=10
+B: LED=1f
+
+I: Bus=0019 Vendor=0000 Product=0001 Version=0000
+N: Name="Power Button"
+H: Handlers=kbd event0
+B: PROP=0
+B: EV=3
+B: KEY=10000000000000 0
+`
+
+	originalReadFile := readFile
+	readFile = func(name string) ([]byte, error) {
+		return []byte(devices), nil
+	}
+	defer func() {
+		readFile = originalReadFile
+	}()
+
+	got, err := FindRelayedDevices([]string{"keyboard", "mouse", "touchpad"}, nil)
+	if err != nil {
+		t.Fatalf("FindRelayedDevices() error = %v", err)
+	}
+	expected := []RelayedDevice{
+		{Path: "/dev/input/event2", Types: []string{"keyboard"}},
+		{Path: "/dev/input/event3", Types: []string{"keyboard"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("FindRelayedDevices() = %v, want %v", got, expected)
+	}
+}
+
+func TestResolveInputPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"event3", "event7", "mouse2"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"usb-Logitech_USB_Receiver-event-kbd":   "event3",
+		"usb-Logitech_USB_Receiver-event-mouse": "event7",
+		"usb-Logitech_USB_Receiver-if01-event":  "event7",
+		"usb-Logitech_USB_Receiver-mouse":       "mouse2", // Legacy mouse node, also matched by *mouse
+		"platform-gone-event-kbd":               "event9",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pattern  string
+		expected []string
+	}{
+		{"Event device", "event3", []string{"event3"}},
+		{"Symlink", "usb-Logitech_USB_Receiver-event-kbd", []string{"event3"}},
+		{"Glob over symlinks to one device", "usb-Logitech_USB_Receiver-*mouse", []string{"event7"}},
+		{"Glob", "usb-Logitech_USB_Receiver-*", []string{"event3", "event7"}},
+		{"Dangling symlink", "platform-gone-event-kbd", nil},
+		{"Missing device", "event12", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, err := ResolveInputPaths(filepath.Join(dir, tt.pattern))
+			if err != nil {
+				t.Fatalf("ResolveInputPaths() error = %v", err)
+			}
+
+			var expected []string
+			for _, name := range tt.expected {
+				expected = append(expected, filepath.Join(dir, name))
+			}
+			if !reflect.DeepEqual(paths, expected) {
+				t.Errorf("ResolveInputPaths() = %v, want %v", paths, expected)
+			}
+		})
+	}
+
+	if _, err := ResolveInputPaths("/dev/input/[event"); err == nil {
+		t.Error("ResolveInputPaths() with a bad pattern succeeded, want error")
+	}
+	if _, err := ResolveInputPaths(filepath.Join(dir, "usb-Logitech_USB_Receiver-mouse")); err == nil {
+		t.Error("ResolveInputPaths() with only a legacy mouse node succeeded, want error")
+	}
+}
@@ -0,0 +1,293 @@
+package device
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// InputDevice is an input device as listed in /proc/bus/input/devices.
+type InputDevice struct {
+	Bus     uint16 // BUS_USB (0x03), BUS_BLUETOOTH (0x05), ...
+	Vendor  uint16
+	Product uint16
+	Version uint16
+
+	Name     string
+	Phys     string // Physical path, e.g. the Bluetooth adapter's MAC for Bluetooth devices
+	Sysfs    string
+	Uniq     string   // Unique identifier, the device's MAC for Bluetooth devices
+	Handlers []string // e.g. "event4", "mouse0", "kbd"
+
+	// Capability bitmaps from the "B:" lines, keyed by name: "EV", "KEY",
+	// "REL", "ABS", "MSC", "LED", "PROP", ...
+	Capabilities map[string]Bitmap
+}
+
+// Bitmap is a capability bitmap, with bit n in word n/64.
+type Bitmap []uint64
+
+// Filter selects input devices.
+type Filter func(dev InputDevice) bool
+
+// Has reports whether a bit is set in the bitmap.
+func (b Bitmap) Has(bit int) bool {
+	word := bit / 64
+	return bit >= 0 && word < len(b) && b[word]&(1<<(bit%64)) != 0
+}
+
+// Has reports whether the device has a capability, e.g. Has("KEY", 30)
+// for KEY_A.
+func (dev InputDevice) Has(capability string, bit int) bool {
+	return dev.Capabilities[capability].Has(bit)
+}
+
+// EventPath returns the path of the device's evdev node, or "" if it has
+// none.
+func (dev InputDevice) EventPath() string {
+	for _, handler := range dev.Handlers {
+		if strings.HasPrefix(handler, "event") {
+			return "/dev/input/" + handler
+		}
+	}
+	return ""
+}
+
+// ListInputDevices returns all input devices known to the kernel.
+func ListInputDevices() ([]InputDevice, error) {
+	data, err := readFile("/proc/bus/input/devices")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read devices: %v", err)
+	}
+	return parseInputDevices(string(data))
+}
+
+// FindInputDevices returns the input devices selected by filter.
+func FindInputDevices(filter Filter) ([]InputDevice, error) {
+	devices, err := ListInputDevices()
+	if err != nil {
+		return nil, err
+	}
+	return FilterInputDevices(devices, filter), nil
+}
+
+// FilterInputDevices returns the devices selected by filter.
+func FilterInputDevices(devices []InputDevice, filter Filter) []InputDevice {
+	var selected []InputDevice
+	for _, dev := range devices {
+		if filter(dev) {
+			selected = append(selected, dev)
+		}
+	}
+	return selected
+}
+
+// parseInputDevices parses the contents of /proc/bus/input/devices, where
+// each device is a block of "X: ..." lines and blocks are separated by
+// blank lines.
+func parseInputDevices(data string) ([]InputDevice, error) {
+	var devices []InputDevice
+	var dev *InputDevice
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			dev = nil
+			continue
+		}
+
+		if dev == nil {
+			devices = append(devices, InputDevice{Capabilities: map[string]Bitmap{}})
+			dev = &devices[len(devices)-1]
+		}
+
+		prefix, value, found := strings.Cut(line, ": ")
+		if !found {
+			continue // Not a field line, nothing to learn from it
+		}
+
+		var err error
+		switch prefix {
+		case "I":
+			err = dev.parseID(value)
+		case "N":
+			dev.Name = unquote(strings.TrimPrefix(value, "Name="))
+		case "P":
+			dev.Phys = strings.TrimPrefix(value, "Phys=")
+		case "S":
+			dev.Sysfs = strings.TrimPrefix(value, "Sysfs=")
+		case "U":
+			dev.Uniq = strings.TrimPrefix(value, "Uniq=")
+		case "H":
+			dev.Handlers = strings.Fields(strings.TrimPrefix(value, "Handlers="))
+		case "B":
+			name, words, _ := strings.Cut(value, "=")
+			var bitmap Bitmap
+			if bitmap, err = parseBitmap(words); err == nil {
+				dev.Capabilities[name] = bitmap
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+	}
+
+	return devices, nil
+}
+
+// parseID parses the "I:" line: "Bus=0005 Vendor=046d Product=b023 Version=0016".
+func (dev *InputDevice) parseID(value string) error {
+	fields := map[string]*uint16{"Bus": &dev.Bus, "Vendor": &dev.Vendor, "Product": &dev.Product, "Version": &dev.Version}
+	for _, field := range strings.Fields(value) {
+		name, hex, _ := strings.Cut(field, "=")
+		target, ok := fields[name]
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(hex, 16, 16)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %q", name, hex)
+		}
+		*target = uint16(id)
+	}
+	return nil
+}
+
+func unquote(s string) string {
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		return unquoted
+	}
+	return strings.Trim(s, `"`)
+}
+
+// parseBitmap parses a capability bitmap. The kernel prints it as hex words
+// of its long size, most significant first, leaving out leading zero words.
+// On 32-bit systems, pairs of words are joined into the 64-bit words of the
+// Bitmap.
+func parseBitmap(words string) (Bitmap, error) {
+	fields := strings.Fields(words)
+	bitmap := make(Bitmap, 0, len(fields)*bits.UintSize/64)
+	for i := len(fields) - 1; i >= 0; i-- {
+		word, err := strconv.ParseUint(fields[i], 16, bits.UintSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid capability bitmap %q", words)
+		}
+		if bits.UintSize == 32 && len(bitmap) > 0 && (len(fields)-1-i)%2 == 1 {
+			bitmap[len(bitmap)-1] |= word << 32
+			continue
+		}
+		bitmap = append(bitmap, word)
+	}
+	return bitmap, nil
+}
+
+// Linux input capability bits checked by the device classifiers
+const (
+	evKey           = 1   // EV_KEY
+	evRel           = 2   // EV_REL
+	evAbs           = 3   // EV_ABS
+	relX            = 0   // REL_X
+	relY            = 1   // REL_Y
+	absX            = 0   // ABS_X
+	absY            = 1   // ABS_Y
+	absMTPositionX  = 53  // ABS_MT_POSITION_X
+	absMTPositionY  = 54  // ABS_MT_POSITION_Y
+	btnLeft         = 272 // BTN_LEFT
+	btnJoystick     = 288 // BTN_JOYSTICK, first of the joystick buttons
+	btnGamepad      = 304 // BTN_GAMEPAD, first of the gamepad buttons
+	btnToolPen      = 320 // BTN_TOOL_PEN
+	btnToolFinger   = 325 // BTN_TOOL_FINGER
+	btnTouch        = 330 // BTN_TOUCH
+	inputPropDirect = 1   // INPUT_PROP_DIRECT, set for touchscreens
+)
+
+// Letter keys of a keyboard: KEY_Q to KEY_P, KEY_A to KEY_L and KEY_Z to KEY_M
+var letterKeyRanges = [...][2]int{{16, 25}, {30, 38}, {44, 50}}
+
+// IsKeyboard matches devices with all letter keys, which leaves out the
+// media key and system control sub-devices many keyboards come with.
+func IsKeyboard(dev InputDevice) bool {
+	if !dev.Has("EV", evKey) {
+		return false
+	}
+	for _, keys := range letterKeyRanges {
+		for code := keys[0]; code <= keys[1]; code++ {
+			if !dev.Has("KEY", code) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Ranges of key codes that are keys rather than buttons: KEY_ESC to KEY_MICMUTE,
+// and KEY_OK up to the D-pad buttons
+var keyRanges = [...][2]int{{1, 255}, {352, 543}}
+
+// HasKeys matches devices with any keyboard, media or system key, such as
+// a mouse with extra keys or a presenter.
+func HasKeys(dev InputDevice) bool {
+	if !dev.Has("EV", evKey) {
+		return false
+	}
+	for _, keys := range keyRanges {
+		for code := keys[0]; code <= keys[1]; code++ {
+			if dev.Has("KEY", code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Bus of input devices that belong to the board itself, like its power button
+const busHost = 0x19 // BUS_HOST
+
+// HasControlKeys matches the "Consumer Control" and "System Control"
+// sub-devices of keyboards and receivers: devices with media or system keys
+// but without the letter keys of a keyboard. The board's own buttons are
+// left out.
+func HasControlKeys(dev InputDevice) bool {
+	return dev.Bus != busHost && HasKeys(dev) && !IsKeyboard(dev)
+}
+
+// HasRelativeMotion matches devices with relative X/Y motion, such as a
+// keyboard with a pointing stick.
+func HasRelativeMotion(dev InputDevice) bool {
+	return dev.Has("EV", evRel) && dev.Has("REL", relX) && dev.Has("REL", relY)
+}
+
+// IsMouse matches devices with relative X/Y motion and a left button.
+func IsMouse(dev InputDevice) bool {
+	return HasRelativeMotion(dev) && dev.Has("KEY", btnLeft)
+}
+
+// IsDigitizer matches pen tablets, touchscreens and mice reporting absolute
+// coordinates. Touchpads also report absolute coordinates but are left out,
+// since they move the pointer relative to its position.
+func IsDigitizer(dev InputDevice) bool {
+	if !dev.Has("EV", evAbs) || !dev.Has("ABS", absX) || !dev.Has("ABS", absY) {
+		return false
+	}
+
+	switch {
+	case dev.Has("KEY", btnToolPen):
+		return true
+	case dev.Has("KEY", btnTouch) && dev.Has("PROP", inputPropDirect):
+		return true
+	default:
+		return dev.Has("KEY", btnLeft) && !dev.Has("KEY", btnToolFinger)
+	}
+}
+
+// IsTouchpad matches multitouch touchpads.
+func IsTouchpad(dev InputDevice) bool {
+	return dev.Has("EV", evAbs) && dev.Has("ABS", absMTPositionX) && dev.Has("ABS", absMTPositionY) &&
+		dev.Has("KEY", btnToolFinger) && !dev.Has("KEY", btnToolPen) && !dev.Has("PROP", inputPropDirect)
+}
+
+// IsGamepad matches gamepads and other joysticks.
+func IsGamepad(dev InputDevice) bool {
+	return dev.Has("EV", evAbs) && (dev.Has("KEY", btnGamepad) || dev.Has("KEY", btnJoystick))
+}
@@ -0,0 +1,115 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testInputDevices = `I: Bus=0005 Vendor=046d Product=b023 Version=0016
+N: Name="MX Master 3"
+P: Phys=b8:27:eb:12:34:56
+S: Sysfs=/devices/virtual/misc/uhid/0005:046D:B023.0001/input/input1
+U: Uniq=d4:a8:41:aa:bb:cc
+H: Handlers=event4 mouse0
+B: PROP=0
+B: EV=17
+B: KEY=ffff0000 0 0 0 0
+B: REL=1943
+B: MSC=10
+
+I: Bus=0019 Vendor=0000 Product=0001 Version=0000
+N: Name="Power Button"
+P: Phys=LNXPWRBN/button/input0
+S: Sysfs=/devices/LNXSYSTM:00/LNXPWRBN:00/input/input0
+U: Uniq=
+H: Handlers=kbd event0
+B: PROP=0
+B: EV=3
+B: KEY=10000000000000 0
+`
+
+func TestParseInputDevices(t *testing.T) {
+	devices, err := parseInputDevices(testInputDevices)
+	if err != nil {
+		t.Fatalf("parseInputDevices() error = %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("parseInputDevices() returned %d devices, want 2", len(devices))
+	}
+
+	want := InputDevice{
+		Bus:      0x0005,
+		Vendor:   0x046d,
+		Product:  0xb023,
+		Version:  0x0016,
+		Name:     "MX Master 3",
+		Phys:     "b8:27:eb:12:34:56",
+		Sysfs:    "/devices/virtual/misc/uhid/0005:046D:B023.0001/input/input1",
+		Uniq:     "d4:a8:41:aa:bb:cc",
+		Handlers: []string{"event4", "mouse0"},
+		Capabilities: map[string]Bitmap{
+			"PROP": {0},
+			"EV":   {0x17},
+			"KEY":  {0, 0, 0, 0, 0xffff0000},
+			"REL":  {0x1943},
+			"MSC":  {0x10},
+		},
+	}
+	if !reflect.DeepEqual(devices[0], want) {
+		t.Errorf("parseInputDevices()[0] = %+v, want %+v", devices[0], want)
+	}
+
+	power := devices[1]
+	if power.Uniq != "" || power.EventPath() != "/dev/input/event0" || !power.Has("KEY", 116) { // KEY_POWER
+		t.Errorf("parseInputDevices()[1] = %+v, want the power button", power)
+	}
+}
+
+func TestParseInputDevices_Invalid(t *testing.T) {
+	for _, data := range []string{
+		"I: Bus=xyz Vendor=0000\n",
+		"B: KEY=12 zz\n",
+	} {
+		if _, err := parseInputDevices(data); err == nil {
+			t.Errorf("parseInputDevices(%q) succeeded, want error", data)
+		}
+	}
+}
+
+func TestFindInputDevices(t *testing.T) {
+	originalReadFile := readFile
+	readFile = func(name string) ([]byte, error) {
+		return []byte(testInputDevices), nil
+	}
+	defer func() {
+		readFile = originalReadFile
+	}()
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "mice", filter: IsMouse, want: []string{"MX Master 3"}},
+		{name: "keyboards", filter: IsKeyboard, want: nil},
+		{name: "name", filter: NameContains("power"), want: []string{"Power Button"}},
+		{name: "Bluetooth", filter: func(dev InputDevice) bool { return dev.Bus == 0x05 }, want: []string{"MX Master 3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices, err := FindInputDevices(tt.filter)
+			if err != nil {
+				t.Fatalf("FindInputDevices() error = %v", err)
+			}
+
+			var names []string
+			for _, dev := range devices {
+				names = append(names, dev.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("FindInputDevices() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package device
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Rule decides whether the input devices it matches are relayed. A rule
+// matches a device when all of its conditions do; a rule without conditions
+// matches every device.
+//
+// Rules are written as an action followed by key=value conditions, e.g.
+//
+//	deny name="Power Button"
+//	allow uniq=dc:2c:26:01:02:03 type=keyboard
+//	allow bus=usb vendor=046d product=c52b
+//	allow name="*K380*" system=off
+//	deny
+//
+// Values containing spaces are quoted with double or single quotes.
+type Rule struct {
+	Deny bool // Matching devices are not relayed
+
+	Name    string // Glob matched against the device name, ignoring case
+	Uniq    string // Unique identifier, the device's address for Bluetooth devices
+	Phys    string // Glob matched against the physical path
+	Bus     uint16 // Bus type, 0 for any
+	Vendor  uint16 // Vendor ID, 0 for any
+	Product uint16 // Product ID, 0 for any
+
+	// Type relays matching devices as this type only, whatever their
+	// capabilities suggest. Only used by allow rules.
+	Type string
+
+	// SystemControl, when set, decides whether the power, sleep and wake
+	// keys of matching devices are relayed, instead of the relay's default.
+	// Only used by allow rules.
+	SystemControl *bool
+}
+
+// Rules decide which devices are relayed. The first rule matching a device
+// applies; devices that no rule matches are relayed by their capabilities,
+// so a final "deny" turns the rules into an allow list.
+type Rules []Rule
+
+// Bus types by name, as used in the I: lines of /proc/bus/input/devices
+var busTypes = map[string]uint16{
+	"pci":       0x01,
+	"usb":       0x03,
+	"bluetooth": 0x05,
+	"virtual":   0x06,
+	"i2c":       0x18,
+	"host":      0x19,
+	"spi":       0x1c,
+}
+
+// ParseRule parses a rule written as described for Rule.
+func ParseRule(s string) (Rule, error) {
+	fields, err := splitRuleFields(s)
+	if err != nil {
+		return Rule{}, err
+	}
+	if len(fields) == 0 {
+		return Rule{}, fmt.Errorf("empty rule")
+	}
+
+	var rule Rule
+	switch fields[0] {
+	case "allow":
+	case "deny":
+		rule.Deny = true
+	default:
+		return Rule{}, fmt.Errorf("rule must start with allow or deny, got %q", fields[0])
+	}
+
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return Rule{}, fmt.Errorf("invalid condition %q, expected key=value", field)
+		}
+
+		switch key {
+		case "name":
+			rule.Name = value
+		case "uniq":
+			rule.Uniq = value
+		case "phys":
+			rule.Phys = value
+		case "bus":
+			if rule.Bus, err = parseBus(value); err != nil {
+				return Rule{}, err
+			}
+		case "vendor":
+			if rule.Vendor, err = parseRuleID(value); err != nil {
+				return Rule{}, fmt.Errorf("invalid vendor: %v", err)
+			}
+		case "product":
+			if rule.Product, err = parseRuleID(value); err != nil {
+				return Rule{}, fmt.Errorf("invalid product: %v", err)
+			}
+		case "type":
+			if _, ok := deviceClassifiers[value]; !ok {
+				return Rule{}, fmt.Errorf("unknown device type %q", value)
+			}
+			rule.Type = value
+		case "system":
+			enabled, err := parseSwitch(value)
+			if err != nil {
+				return Rule{}, fmt.Errorf("invalid system: %v", err)
+			}
+			rule.SystemControl = &enabled
+		default:
+			return Rule{}, fmt.Errorf("unknown condition %q", key)
+		}
+
+		// Bad globs fail here rather than silently never matching
+		if key == "name" || key == "phys" {
+			if _, err := path.Match(value, ""); err != nil {
+				return Rule{}, fmt.Errorf("invalid %s pattern %q: %v", key, value, err)
+			}
+		}
+	}
+
+	if rule.Deny && rule.Type != "" {
+		return Rule{}, fmt.Errorf("type can only be set by allow rules")
+	}
+	if rule.Deny && rule.SystemControl != nil {
+		return Rule{}, fmt.Errorf("system can only be set by allow rules")
+	}
+
+	return rule, nil
+}
+
+// splitRuleFields splits a rule at spaces outside of quotes and removes the
+// quotes.
+func splitRuleFields(s string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	inField := false
+
+	for _, c := range s {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote, inField = c, true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(c)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in rule %q", s)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func parseBus(value string) (uint16, error) {
+	if bus, ok := busTypes[strings.ToLower(value)]; ok {
+		return bus, nil
+	}
+	bus, err := parseRuleID(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bus %q", value)
+	}
+	return bus, nil
+}
+
+// parseRuleID parses a hexadecimal ID, written as in /proc/bus/input/devices
+// or lsusb, with or without 0x.
+func parseRuleID(value string) (uint16, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(value), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a hexadecimal ID", value)
+	}
+	return uint16(id), nil
+}
+
+// parseSwitch parses an on/off option value.
+func parseSwitch(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is neither on nor off", value)
+}
+
+// Matches reports whether the device meets all of the rule's conditions.
+func (r Rule) Matches(dev InputDevice) bool {
+	if r.Name != "" && !globMatch(strings.ToLower(r.Name), strings.ToLower(dev.Name)) {
+		return false
+	}
+	if r.Uniq != "" && !strings.EqualFold(r.Uniq, dev.Uniq) {
+		return false
+	}
+	if r.Phys != "" && !globMatch(r.Phys, dev.Phys) {
+		return false
+	}
+	if r.Bus != 0 && r.Bus != dev.Bus {
+		return false
+	}
+	if r.Vendor != 0 && r.Vendor != dev.Vendor {
+		return false
+	}
+	if r.Product != 0 && r.Product != dev.Product {
+		return false
+	}
+	return true
+}
+
+// globMatch matches a glob whose syntax ParseRule has checked. Unlike paths,
+// names and physical paths may contain "/", which * matches too.
+func globMatch(pattern, s string) bool {
+	matched, _ := path.Match(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(s, "/", "\x00"))
+	return matched
+}
+
+// Match returns the first rule matching the device.
+func (rules Rules) Match(dev InputDevice) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.Matches(dev) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// SystemControl reports whether the power, sleep and wake keys of a device
+// are relayed: as set by the first rule matching it, or as defaultValue when
+// that rule doesn't say.
+func (rules Rules) SystemControl(dev InputDevice, defaultValue bool) bool {
+	if rule, ok := rules.Match(dev); ok && rule.SystemControl != nil {
+		return *rule.SystemControl
+	}
+	return defaultValue
+}
+
+// Filter matches the devices relayed as the given type: devices allowed with
+// that type, and devices of that type that no rule denies or assigns to
+// another type.
+func (rules Rules) Filter(deviceType string) Filter {
+	classify, ok := deviceClassifiers[deviceType]
+	if !ok {
+		classify = NameContains(deviceType)
+	}
+
+	return func(dev InputDevice) bool {
+		rule, ok := rules.Match(dev)
+		switch {
+		case !ok:
+			return classify(dev)
+		case rule.Deny:
+			return false
+		case rule.Type != "":
+			return rule.Type == deviceType
+		default:
+			return classify(dev)
+		}
+	}
+}
+
+// Types returns the types, out of the given ones, that a device is relayed
+// as. A device can be relayed as several types, each one receiving the
+// events it understands. Besides the types it is recognized as, a mouse with
+// keys is also relayed as a keyboard and a keyboard with relative motion as
+// a mouse, and a device with only media or system keys is relayed as a
+// keyboard, unless a rule assigned it a type.
+func (rules Rules) Types(dev InputDevice, deviceTypes []string) []string {
+	var types []string
+	for _, deviceType := range deviceTypes {
+		if rules.Filter(deviceType)(dev) {
+			types = append(types, deviceType)
+		}
+	}
+
+	if rule, ok := rules.Match(dev); ok && rule.Type != "" {
+		return types
+	}
+
+	keyboard, mouse := containsString(types, "keyboard"), containsString(types, "mouse")
+	if mouse && !keyboard && containsString(deviceTypes, "keyboard") && HasKeys(dev) {
+		types = append(types, "keyboard")
+	}
+	if keyboard && !mouse && containsString(deviceTypes, "mouse") && HasRelativeMotion(dev) {
+		types = append(types, "mouse")
+	}
+	if len(types) == 0 && containsString(deviceTypes, "keyboard") && HasControlKeys(dev) {
+		if rule, ok := rules.Match(dev); !ok || !rule.Deny {
+			types = append(types, "keyboard")
+		}
+	}
+	return types
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
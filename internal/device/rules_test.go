@@ -0,0 +1,220 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	off := false
+	tests := []struct {
+		rule     string
+		expected Rule
+	}{
+		{`deny name="Power Button"`, Rule{Deny: true, Name: "Power Button"}},
+		{`allow uniq=DC:2C:26:01:02:03 type=keyboard`, Rule{Uniq: "DC:2C:26:01:02:03", Type: "keyboard"}},
+		{`allow bus=usb vendor=046d product=0xC52B`, Rule{Bus: 0x03, Vendor: 0x046d, Product: 0xc52b}},
+		{`deny bus=0019 phys='gpio-keys/*'`, Rule{Deny: true, Bus: 0x19, Phys: "gpio-keys/*"}},
+		{`allow name=*K380* system=off`, Rule{Name: "*K380*", SystemControl: &off}},
+		{`  deny  `, Rule{Deny: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := ParseRule(tt.rule)
+			if err != nil {
+				t.Fatalf("ParseRule() error = %v", err)
+			}
+			if !reflect.DeepEqual(rule, tt.expected) {
+				t.Errorf("ParseRule() = %+v, want %+v", rule, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseRule_Invalid(t *testing.T) {
+	for _, rule := range []string{
+		``,
+		`block name=K380`,
+		`allow name`,
+		`allow name="K380`,
+		`allow name=[K380`,
+		`allow color=red`,
+		`allow vendor=xyz`,
+		`allow bus=firewire`,
+		`allow type=printer`,
+		`deny type=keyboard`,
+		`allow system=maybe`,
+		`deny system=off`,
+	} {
+		if _, err := ParseRule(rule); err == nil {
+			t.Errorf("ParseRule(%q) succeeded, want error", rule)
+		}
+	}
+}
+
+func TestRules_Filter(t *testing.T) {
+	letterKeys := Bitmap{(1<<51 - 1) &^ (1<<16 - 1)}
+	keyboard := InputDevice{
+		Bus: 0x05, Vendor: 0x046d, Product: 0xb342,
+		Name: "Keyboard K380", Phys: "b8:27:eb:12:34:56", Uniq: "dc:2c:26:01:02:03",
+		Capabilities: map[string]Bitmap{"EV": {0x3}, "KEY": letterKeys},
+	}
+	neighbour := InputDevice{
+		Bus: 0x05, Vendor: 0x05ac, Product: 0x0267,
+		Name: "Magic Keyboard", Phys: "b8:27:eb:12:34:56", Uniq: "f0:b3:ec:0a:0b:0c",
+		Capabilities: map[string]Bitmap{"EV": {0x3}, "KEY": letterKeys},
+	}
+	numpad := InputDevice{ // No letter keys, so not a keyboard by its capabilities
+		Bus: 0x03, Vendor: 0x1a2c, Product: 0x0e24,
+		Name: "USB Keypad", Phys: "usb-3f980000.usb-1/input0",
+		Capabilities: map[string]Bitmap{"EV": {0x3}, "KEY": {0, 0xffe0000000000000}},
+	}
+	mouse := InputDevice{
+		Bus: 0x05, Vendor: 0x046d, Product: 0xb023,
+		Name: "MX Master 3", Phys: "b8:27:eb:12:34:56", Uniq: "d4:a8:41:aa:bb:cc",
+		Capabilities: map[string]Bitmap{"EV": {0x7}, "KEY": {0, 0, 0, 0, 0x10000}, "REL": {0x3}},
+	}
+	devices := []InputDevice{keyboard, neighbour, numpad, mouse}
+
+	tests := []struct {
+		name       string
+		rules      []string
+		deviceType string
+		expected   []string
+	}{
+		{
+			name:       "No rules",
+			deviceType: "keyboard",
+			expected:   []string{"Keyboard K380", "Magic Keyboard"},
+		},
+		{
+			name:       "Deny by address",
+			rules:      []string{"deny uniq=F0:B3:EC:0A:0B:0C"},
+			deviceType: "keyboard",
+			expected:   []string{"Keyboard K380"},
+		},
+		{
+			name:       "Assign a type",
+			rules:      []string{"allow vendor=1a2c product=0e24 type=keyboard"},
+			deviceType: "keyboard",
+			expected:   []string{"Keyboard K380", "Magic Keyboard", "USB Keypad"},
+		},
+		{
+			name:       "Assigned devices leave other types",
+			rules:      []string{"allow name=mx* type=keyboard"},
+			deviceType: "mouse",
+			expected:   nil,
+		},
+		{
+			name:       "Allow list",
+			rules:      []string{"allow name=*k380", "allow bus=usb type=keyboard", "deny"},
+			deviceType: "keyboard",
+			expected:   []string{"Keyboard K380", "USB Keypad"},
+		},
+		{
+			name:       "Allow list by physical path",
+			rules:      []string{"allow phys=usb-*/input0 type=mouse", "deny"},
+			deviceType: "mouse",
+			expected:   []string{"USB Keypad"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules Rules
+			for _, s := range tt.rules {
+				rule, err := ParseRule(s)
+				if err != nil {
+					t.Fatalf("ParseRule(%q) error = %v", s, err)
+				}
+				rules = append(rules, rule)
+			}
+
+			var names []string
+			for _, dev := range FilterInputDevices(devices, rules.Filter(tt.deviceType)) {
+				names = append(names, dev.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("Filter(%q) matched %v, want %v", tt.deviceType, names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRules_Types(t *testing.T) {
+	letterKeys := Bitmap{(1<<51 - 1) &^ (1<<16 - 1)}
+	pointingStick := InputDevice{ // Keyboard whose pointing stick reports motion on the same node
+		Name:         "ThinkPad Compact Bluetooth Keyboard with TrackPoint",
+		Capabilities: map[string]Bitmap{"EV": {0x7}, "KEY": letterKeys, "REL": {0x3}},
+	}
+	presenter := InputDevice{ // Page Up/Down keys (104, 109) along with air mouse motion
+		Name:         "Presenter",
+		Capabilities: map[string]Bitmap{"EV": {0x7}, "KEY": {0, 1<<(104-64) | 1<<(109-64), 0, 0, 0x10000}, "REL": {0x3}},
+	}
+	mediaKeys := InputDevice{ // KEY_MUTE to KEY_VOLUMEUP (113-115)
+		Name:         "K380 Consumer Control",
+		Bus:          0x05,
+		Capabilities: map[string]Bitmap{"EV": {0x3}, "KEY": {0, 1<<(113-64) | 1<<(114-64) | 1<<(115-64)}},
+	}
+	powerButton := InputDevice{ // KEY_POWER (116)
+		Name:         "Power Button",
+		Bus:          0x19,
+		Capabilities: map[string]Bitmap{"EV": {0x3}, "KEY": {0, 1 << (116 - 64)}},
+	}
+	mouse := InputDevice{
+		Name:         "MX Master 3",
+		Capabilities: map[string]Bitmap{"EV": {0x7}, "KEY": {0, 0, 0, 0, 0xffff0000}, "REL": {0x3}},
+	}
+
+	tests := []struct {
+		name     string
+		dev      InputDevice
+		rules    Rules
+		expected []string
+	}{
+		{"Keyboard with motion", pointingStick, nil, []string{"keyboard", "mouse"}},
+		{"Mouse with keys", presenter, nil, []string{"mouse", "keyboard"}},
+		{"Mouse with buttons only", mouse, nil, []string{"mouse"}},
+		{"Media keys", mediaKeys, nil, []string{"keyboard"}},
+		{"Denied media keys", mediaKeys, Rules{{Name: "*consumer*", Deny: true}}, nil},
+		{"Board's power button", powerButton, nil, nil},
+		{"Assigned type", presenter, Rules{{Name: "presenter", Type: "mouse"}}, []string{"mouse"}},
+		{"Denied", pointingStick, Rules{{Deny: true}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			types := tt.rules.Types(tt.dev, []string{"keyboard", "mouse", "touchpad"})
+			if !reflect.DeepEqual(types, tt.expected) {
+				t.Errorf("Types() = %v, want %v", types, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRules_SystemControl(t *testing.T) {
+	keyboard := InputDevice{Name: "Keyboard K380", Uniq: "dc:2c:26:01:02:03"}
+	remote := InputDevice{Name: "Media Remote", Uniq: "d4:a8:41:aa:bb:cc"}
+
+	on, off := true, false
+
+	rules := Rules{
+		{Name: "*K380*", SystemControl: &off},
+		{Uniq: "d4:a8:41:aa:bb:cc"},
+	}
+	if rules.SystemControl(keyboard, true) {
+		t.Error("SystemControl() = true for a device whose rule turns it off")
+	}
+	if !rules.SystemControl(remote, true) {
+		t.Error("SystemControl() = false for a device whose rule doesn't set it, want the default")
+	}
+
+	rules = Rules{{Uniq: "d4:a8:41:aa:bb:cc", SystemControl: &on}}
+	if !rules.SystemControl(remote, false) {
+		t.Error("SystemControl() = false for a device whose rule turns it on")
+	}
+	if rules.SystemControl(keyboard, false) {
+		t.Error("SystemControl() = true for a device no rule matches, want the default")
+	}
+}
@@ -0,0 +1,129 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Uevent is a kernel device event, sent when a device is added, removed or
+// changed.
+type Uevent struct {
+	Action    string // add, remove, change, ...
+	DevPath   string // Sysfs path, e.g. /devices/virtual/misc/uhid/.../input/input12/event5
+	Subsystem string
+	DevName   string // Device node relative to /dev, e.g. input/event5; empty without one
+}
+
+// EventNode returns the device node the event refers to, or an empty string
+// if it has none.
+func (e Uevent) EventNode() string {
+	if e.DevName == "" {
+		return ""
+	}
+	return "/dev/" + e.DevName
+}
+
+// IsInputEventNode reports whether the event refers to an evdev node, the
+// part of an input device the relay reads.
+func (e Uevent) IsInputEventNode() bool {
+	return e.Subsystem == "input" && strings.HasPrefix(e.DevName, "input/event")
+}
+
+// Multicast group the kernel sends its uevents to; group 2 carries the same
+// events after udev has processed them, which not every system runs.
+const ueventKernelGroup = 1
+
+// ueventBufferSize fits the largest uevent the kernel sends (UEVENT_BUFFER_SIZE)
+const ueventBufferSize = 2048
+
+// WatchInputDevices listens for kernel uevents on the input subsystem and
+// sends the events of evdev nodes until ctx is done, when the channel is
+// closed. Events are dropped while the receiver is busy, so receivers should
+// rescan the devices rather than rely on seeing every event.
+func WatchInputDevices(ctx context.Context) (<-chan Uevent, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uevent socket: %v", err)
+	}
+
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: ueventKernelGroup}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to bind uevent socket: %v", err)
+	}
+
+	// A non-blocking socket is read through the runtime poller, so closing
+	// the file ends a pending read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to set up uevent socket: %v", err)
+	}
+	socket := os.NewFile(uintptr(fd), "uevent")
+
+	go func() {
+		<-ctx.Done()
+		socket.Close()
+	}()
+
+	events := make(chan Uevent, 16)
+	go func() {
+		defer close(events)
+
+		buf := make([]byte, ueventBufferSize)
+		for {
+			n, err := socket.Read(buf)
+			if errors.Is(err, syscall.ENOBUFS) { // Events were lost, keep going
+				continue
+			}
+			if err != nil {
+				return
+			}
+
+			event, ok := parseUevent(buf[:n])
+			if !ok || !event.IsInputEventNode() {
+				continue
+			}
+
+			select {
+			case events <- event:
+			default:
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// parseUevent parses a kernel uevent message: an "action@devpath" header
+// followed by NUL-separated KEY=value pairs. Messages relayed by udev, which
+// start with "libudev", are not kernel uevents and are rejected.
+func parseUevent(data []byte) (Uevent, bool) {
+	fields := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	if len(fields) == 0 || !strings.Contains(fields[0], "@") {
+		return Uevent{}, false
+	}
+
+	var event Uevent
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "ACTION":
+			event.Action = value
+		case "DEVPATH":
+			event.DevPath = value
+		case "SUBSYSTEM":
+			event.Subsystem = value
+		case "DEVNAME":
+			event.DevName = value
+		}
+	}
+
+	return event, event.Action != ""
+}
@@ -0,0 +1,73 @@
+package device
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUevent(t *testing.T) {
+	tests := []struct {
+		name      string
+		message   []string
+		expected  Uevent
+		ok        bool
+		eventNode bool
+	}{
+		{
+			name: "Event node added",
+			message: []string{
+				"add@/devices/virtual/misc/uhid/0005:046D:B023.0001/input/input12/event5",
+				"ACTION=add",
+				"DEVPATH=/devices/virtual/misc/uhid/0005:046D:B023.0001/input/input12/event5",
+				"SUBSYSTEM=input",
+				"MAJOR=13",
+				"MINOR=69",
+				"DEVNAME=input/event5",
+				"SEQNUM=2734",
+			},
+			expected: Uevent{
+				Action:    "add",
+				DevPath:   "/devices/virtual/misc/uhid/0005:046D:B023.0001/input/input12/event5",
+				Subsystem: "input",
+				DevName:   "input/event5",
+			},
+			ok:        true,
+			eventNode: true,
+		},
+		{
+			name: "Input device without node",
+			message: []string{
+				"add@/devices/virtual/misc/uhid/0005:046D:B023.0001/input/input12",
+				"ACTION=add",
+				"DEVPATH=/devices/virtual/misc/uhid/0005:046D:B023.0001/input/input12",
+				"SUBSYSTEM=input",
+				"NAME=\"MX Master 3\"",
+			},
+			expected: Uevent{
+				Action:    "add",
+				DevPath:   "/devices/virtual/misc/uhid/0005:046D:B023.0001/input/input12",
+				Subsystem: "input",
+			},
+			ok: true,
+		},
+		{
+			name:    "Message relayed by udev",
+			message: []string{"libudev\x00\xfe\xed\xca\xfe"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := parseUevent([]byte(strings.Join(tt.message, "\x00") + "\x00"))
+			if ok != tt.ok {
+				t.Fatalf("parseUevent() ok = %v, want %v", ok, tt.ok)
+			}
+			if event != tt.expected {
+				t.Errorf("parseUevent() = %+v, want %+v", event, tt.expected)
+			}
+			if event.IsInputEventNode() != tt.eventNode {
+				t.Errorf("IsInputEventNode() = %v, want %v", event.IsInputEventNode(), tt.eventNode)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package relay
+
+import (
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
+)
+
+// ConsumerRelay converts media and application keys into Consumer Control
+// reports. Each report carries a report ID followed by a single 16-bit
+// Consumer page usage, matching the consumer gadget descriptor in
+// scripts/setup_gadgets.sh.
+type ConsumerRelay struct {
+	pressed []uint16 // Consumer usages of held keys, in press order
+}
+
+const consumerReportID = 0x01
+
+// Linux event code to Consumer page usage ID mapping
+var consumerKeyMap = map[uint16]uint16{
+	113: 0x0E2, // KEY_MUTE
+	114: 0x0EA, // KEY_VOLUMEDOWN
+	115: 0x0E9, // KEY_VOLUMEUP
+	140: 0x192, // KEY_CALC (AL Calculator)
+	144: 0x194, // KEY_FILE (AL Local Machine Browser)
+	150: 0x196, // KEY_WWW (AL Internet Browser)
+	152: 0x19E, // KEY_SCREENLOCK (AL Terminal Lock)
+	155: 0x18A, // KEY_MAIL (AL Email Reader)
+	156: 0x22A, // KEY_BOOKMARKS (AC Bookmarks)
+	158: 0x224, // KEY_BACK (AC Back)
+	159: 0x225, // KEY_FORWARD (AC Forward)
+	161: 0x0B8, // KEY_EJECTCD
+	162: 0x0B8, // KEY_EJECTCLOSECD
+	163: 0x0B5, // KEY_NEXTSONG (Scan Next Track)
+	164: 0x0CD, // KEY_PLAYPAUSE
+	165: 0x0B6, // KEY_PREVIOUSSONG (Scan Previous Track)
+	166: 0x0B7, // KEY_STOPCD
+	167: 0x0B2, // KEY_RECORD
+	168: 0x0B4, // KEY_REWIND
+	171: 0x183, // KEY_CONFIG (AL Consumer Control Configuration)
+	172: 0x223, // KEY_HOMEPAGE (AC Home)
+	173: 0x227, // KEY_REFRESH (AC Refresh)
+	200: 0x0B0, // KEY_PLAYCD
+	201: 0x0B1, // KEY_PAUSECD
+	207: 0x0B0, // KEY_PLAY
+	208: 0x0B3, // KEY_FASTFORWARD
+	217: 0x221, // KEY_SEARCH (AC Search)
+	224: 0x070, // KEY_BRIGHTNESSDOWN
+	225: 0x06F, // KEY_BRIGHTNESSUP
+}
+
+func (c *ConsumerRelay) convertEvent(event InputEvent) ([]byte, error) {
+	usage, exists := consumerKeyMap[event.Code]
+	if !exists {
+		logger.DebugPrintf("No consumer mapping for key code: %d", event.Code)
+		return nil, nil
+	}
+
+	switch event.Value {
+	case 0: // Release
+		for i, held := range c.pressed {
+			if held == usage {
+				c.pressed = append(c.pressed[:i], c.pressed[i+1:]...)
+				break
+			}
+		}
+		return c.report(), nil
+	case 1: // Press
+		for _, held := range c.pressed {
+			if held == usage {
+				return nil, nil
+			}
+		}
+		c.pressed = append(c.pressed, usage)
+		return c.report(), nil
+	}
+
+	// Auto-repeat is left to the host
+	return nil, nil
+}
+
+// report builds a consumer report for the most recently pressed key that is
+// still held; the descriptor only has room for one usage at a time.
+func (c *ConsumerRelay) report() []byte {
+	report := c.releaseReport()
+	if len(c.pressed) > 0 {
+		usage := c.pressed[len(c.pressed)-1]
+		report[1] = byte(usage)
+		report[2] = byte(usage >> 8)
+	}
+	return report
+}
+
+func (c *ConsumerRelay) validateEvent(event InputEvent) bool {
+	if event.Type != 1 { // EV_KEY
+		return false
+	}
+	_, exists := consumerKeyMap[event.Code]
+	return exists
+}
+
+func (c *ConsumerRelay) releaseReport() []byte {
+	return []byte{consumerReportID, 0, 0}
+}
+
+func (c *ConsumerRelay) name() string {
+	return "consumer"
+}
@@ -0,0 +1,53 @@
+package relay
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConsumerRelay_ConvertEvent(t *testing.T) {
+	steps := []struct {
+		name       string
+		event      InputEvent
+		wantReport []byte
+	}{
+		{"volume up press", InputEvent{Type: 1, Code: 115, Value: 1}, []byte{0x01, 0xE9, 0x00}},
+		{"volume up repeat", InputEvent{Type: 1, Code: 115, Value: 2}, nil},
+		{"play/pause while volume held", InputEvent{Type: 1, Code: 164, Value: 1}, []byte{0x01, 0xCD, 0x00}},
+		{"play/pause release falls back to volume", InputEvent{Type: 1, Code: 164, Value: 0}, []byte{0x01, 0xE9, 0x00}},
+		{"volume up release", InputEvent{Type: 1, Code: 115, Value: 0}, []byte{0x01, 0x00, 0x00}},
+		{"two-byte usage", InputEvent{Type: 1, Code: 172, Value: 1}, []byte{0x01, 0x23, 0x02}},
+	}
+
+	c := &ConsumerRelay{}
+	for _, step := range steps {
+		got, err := c.convertEvent(step.event)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if !bytes.Equal(got, step.wantReport) {
+			t.Fatalf("%s: report = %v, want %v", step.name, got, step.wantReport)
+		}
+	}
+}
+
+func TestConsumerRelay_ValidateEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event InputEvent
+		want  bool
+	}{
+		{"media key", InputEvent{Type: 1, Code: 113}, true},
+		{"letter key", InputEvent{Type: 1, Code: 30}, false},
+		{"sync event", InputEvent{Type: 0}, false},
+	}
+
+	c := &ConsumerRelay{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := c.validateEvent(test.event); got != test.want {
+				t.Errorf("ConsumerRelay.validateEvent() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
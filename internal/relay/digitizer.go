@@ -0,0 +1,249 @@
+package relay
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
+)
+
+// DigitizerRelay converts absolute pointer events from pen tablets,
+// touchscreens and absolute mice into pen digitizer reports: tip, barrel,
+// eraser, invert, secondary barrel and in-range bits, followed by 16-bit X,
+// Y and tip pressure, matching the digitizer gadget descriptor in
+// scripts/setup_gadgets.sh. Coordinates are scaled from the device's axis
+// ranges to the report's logical range, so the whole tablet maps onto the
+// whole screen.
+type DigitizerRelay struct {
+	x, y, pressure axisRange // Axis ranges reported by the device
+
+	posX, posY, pressureValue int32  // Latest raw values
+	buttons                   uint16 // Bitmap of held keys, indexed by code - BTN_TOOL_PEN
+	toolSeen                  bool   // The device reports proximity through BTN_TOOL_* keys
+	touchSeen                 bool   // The device reports BTN_TOUCH, like touchscreens do
+	positionSeen              bool
+	dirty                     bool
+}
+
+// axisRange is the range of an absolute axis. An empty range means the
+// device doesn't have the axis.
+type axisRange struct {
+	min, max int32
+}
+
+const digitizerReportLength = 7
+
+// Logical maximum of the report's coordinate and pressure fields
+const (
+	digitizerMaxCoordinate = 32767
+	digitizerMaxPressure   = 4095
+)
+
+// Linux event codes handled by the digitizer
+const (
+	absXCode          = 0   // ABS_X
+	absYCode          = 1   // ABS_Y
+	absPressureCode   = 24  // ABS_PRESSURE
+	btnLeftCode       = 272 // BTN_LEFT
+	btnRightCode      = 273 // BTN_RIGHT
+	btnMiddleCode     = 274 // BTN_MIDDLE
+	digitizerFirstKey = 320 // BTN_TOOL_PEN
+	digitizerLastKey  = 332 // BTN_STYLUS2
+)
+
+// Report bits of the digitizer's first byte
+const (
+	digitizerTipSwitch       = 0x01
+	digitizerBarrelSwitch    = 0x02
+	digitizerEraser          = 0x04
+	digitizerInvert          = 0x08
+	digitizerSecondaryBarrel = 0x10
+	digitizerInRange         = 0x20
+)
+
+// Linux key codes whose state the digitizer tracks, relative to BTN_TOOL_PEN
+const (
+	toolPenBit      = 1 << (320 - digitizerFirstKey) // BTN_TOOL_PEN
+	toolRubberBit   = 1 << (321 - digitizerFirstKey) // BTN_TOOL_RUBBER
+	toolBrushBit    = 1 << (322 - digitizerFirstKey) // BTN_TOOL_BRUSH
+	toolPencilBit   = 1 << (323 - digitizerFirstKey) // BTN_TOOL_PENCIL
+	toolAirbrushBit = 1 << (324 - digitizerFirstKey) // BTN_TOOL_AIRBRUSH
+	toolFingerBit   = 1 << (325 - digitizerFirstKey) // BTN_TOOL_FINGER
+	touchBit        = 1 << (330 - digitizerFirstKey) // BTN_TOUCH
+	stylusBit       = 1 << (331 - digitizerFirstKey) // BTN_STYLUS
+	stylus2Bit      = 1 << (332 - digitizerFirstKey) // BTN_STYLUS2
+)
+
+const toolBits = toolPenBit | toolRubberBit | toolBrushBit | toolPencilBit | toolAirbrushBit | toolFingerBit
+
+// probeDevice reads the ranges of the absolute axes from the input device.
+func (d *DigitizerRelay) probeDevice(inputFile *os.File) error {
+	for _, axis := range []struct {
+		code     uint16
+		rng      *axisRange
+		required bool
+	}{
+		{absXCode, &d.x, true},
+		{absYCode, &d.y, true},
+		{absPressureCode, &d.pressure, false},
+	} {
+		info, err := readAbsInfo(inputFile, axis.code)
+		if err != nil {
+			if axis.required {
+				return fmt.Errorf("failed to read range of axis %d: %v", axis.code, err)
+			}
+			*axis.rng = axisRange{}
+			continue
+		}
+		*axis.rng = axisRange{min: info.Minimum, max: info.Maximum}
+		logger.DebugPrintf("Digitizer axis %d: range %d to %d, value %d", axis.code, info.Minimum, info.Maximum, info.Value)
+	}
+
+	if d.x.max <= d.x.min || d.y.max <= d.y.min {
+		return fmt.Errorf("device reports no usable X/Y range")
+	}
+	return nil
+}
+
+func (d *DigitizerRelay) convertEvent(event InputEvent) ([]byte, error) {
+	logger.DebugPrintf("Digitizer event: type=%d, code=%d, value=%d", event.Type, event.Code, event.Value)
+
+	switch event.Type {
+	case 0: // EV_SYN
+		if event.Code == 0 && d.dirty { // SYN_REPORT
+			d.dirty = false
+			return d.report(), nil
+		}
+	case 1: // EV_KEY
+		bit := d.keyBit(event.Code)
+		if bit&toolBits != 0 {
+			d.toolSeen = true
+		}
+		if event.Code == 330 { // BTN_TOUCH
+			d.touchSeen = true
+		}
+		switch event.Value {
+		case 1: // Press
+			d.buttons |= bit
+		case 0: // Release
+			d.buttons &^= bit
+		default: // Auto-repeat doesn't change the state
+			return nil, nil
+		}
+		d.dirty = true
+	case 3: // EV_ABS
+		switch event.Code {
+		case absXCode:
+			d.posX = event.Value
+			d.positionSeen = true
+		case absYCode:
+			d.posY = event.Value
+			d.positionSeen = true
+		case absPressureCode:
+			d.pressureValue = event.Value
+		}
+		d.dirty = true
+	}
+
+	return nil, nil
+}
+
+// keyBit returns the state bit of a key. Absolute mice report mouse buttons,
+// which act as the tip and barrel switches.
+func (d *DigitizerRelay) keyBit(code uint16) uint16 {
+	switch code {
+	case btnLeftCode:
+		return touchBit
+	case btnRightCode:
+		return stylusBit
+	case btnMiddleCode:
+		return stylus2Bit
+	}
+	return 1 << (code - digitizerFirstKey)
+}
+
+// report builds a digitizer report from the current state. Devices without
+// proximity reporting are in range while touched if they report BTN_TOUCH,
+// so a touchscreen leaves no cursor behind, and otherwise once they have
+// reported a position, like absolute mice. Devices without pressure press
+// with full pressure.
+func (d *DigitizerRelay) report() []byte {
+	var bits byte
+
+	inRange := d.positionSeen
+	switch {
+	case d.toolSeen:
+		inRange = d.buttons&toolBits != 0
+	case d.touchSeen:
+		inRange = d.buttons&touchBit != 0
+	}
+	if inRange {
+		bits |= digitizerInRange
+	}
+
+	touching := d.buttons&touchBit != 0
+	if d.buttons&toolRubberBit != 0 {
+		// The eraser end replaces the tip while the pen is inverted
+		bits |= digitizerInvert
+		if touching {
+			bits |= digitizerEraser
+		}
+	} else if touching {
+		bits |= digitizerTipSwitch
+	}
+	if d.buttons&stylusBit != 0 {
+		bits |= digitizerBarrelSwitch
+	}
+	if d.buttons&stylus2Bit != 0 {
+		bits |= digitizerSecondaryBarrel
+	}
+
+	x := scaleAxis(d.posX, d.x, digitizerMaxCoordinate)
+	y := scaleAxis(d.posY, d.y, digitizerMaxCoordinate)
+
+	var pressure int32
+	if touching {
+		pressure = digitizerMaxPressure
+		if d.pressure.max > d.pressure.min {
+			pressure = scaleAxis(d.pressureValue, d.pressure, digitizerMaxPressure)
+		}
+	}
+
+	return []byte{bits, byte(x), byte(x >> 8), byte(y), byte(y >> 8), byte(pressure), byte(pressure >> 8)}
+}
+
+// scaleAxis maps a value from an axis range onto 0 to limit.
+func scaleAxis(value int32, rng axisRange, limit int32) int32 {
+	if rng.max <= rng.min {
+		return 0
+	}
+	value = min(max(value, rng.min), rng.max)
+	return int32(int64(value-rng.min) * int64(limit) / int64(rng.max-rng.min))
+}
+
+func (d *DigitizerRelay) validateEvent(event InputEvent) bool {
+	switch event.Type {
+	case 0: // EV_SYN
+		return true // Closes the frame
+	case 1: // EV_KEY
+		switch {
+		case event.Code >= btnLeftCode && event.Code <= btnMiddleCode:
+			return true
+		case event.Code >= digitizerFirstKey && event.Code <= digitizerLastKey:
+			return d.keyBit(event.Code)&(toolBits|touchBit|stylusBit|stylus2Bit) != 0
+		}
+		return false
+	case 3: // EV_ABS
+		return event.Code == absXCode || event.Code == absYCode || event.Code == absPressureCode
+	default:
+		return false
+	}
+}
+
+func (d *DigitizerRelay) releaseReport() []byte {
+	return make([]byte, digitizerReportLength)
+}
+
+func (d *DigitizerRelay) name() string {
+	return "digitizer"
+}
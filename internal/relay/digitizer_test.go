@@ -0,0 +1,135 @@
+package relay
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDigitizerRelay_ConvertEvent(t *testing.T) {
+	tablet := axisRange{min: 0, max: 21600}
+
+	tests := []struct {
+		name     string
+		relay    DigitizerRelay
+		events   []InputEvent
+		expected []byte
+	}{
+		{
+			name:  "Pen hovering",
+			relay: DigitizerRelay{x: tablet, y: tablet, pressure: axisRange{max: 8191}},
+			events: []InputEvent{
+				{Type: 1, Code: 320, Value: 1},   // BTN_TOOL_PEN
+				{Type: 3, Code: 0, Value: 10800}, // ABS_X
+				{Type: 3, Code: 1, Value: 21600}, // ABS_Y
+			},
+			expected: []byte{0x20, 0xff, 0x3f, 0xff, 0x7f, 0, 0},
+		},
+		{
+			name:  "Pen touching with pressure and barrel button",
+			relay: DigitizerRelay{x: tablet, y: tablet, pressure: axisRange{max: 8191}},
+			events: []InputEvent{
+				{Type: 1, Code: 320, Value: 1},   // BTN_TOOL_PEN
+				{Type: 1, Code: 330, Value: 1},   // BTN_TOUCH
+				{Type: 1, Code: 331, Value: 1},   // BTN_STYLUS
+				{Type: 3, Code: 24, Value: 8191}, // ABS_PRESSURE
+			},
+			expected: []byte{0x23, 0, 0, 0, 0, 0xff, 0x0f},
+		},
+		{
+			name:  "Eraser touching",
+			relay: DigitizerRelay{x: tablet, y: tablet},
+			events: []InputEvent{
+				{Type: 1, Code: 321, Value: 1}, // BTN_TOOL_RUBBER
+				{Type: 1, Code: 330, Value: 1}, // BTN_TOUCH
+			},
+			expected: []byte{0x2c, 0, 0, 0, 0, 0xff, 0x0f},
+		},
+		{
+			name:  "Pen leaving proximity",
+			relay: DigitizerRelay{x: tablet, y: tablet},
+			events: []InputEvent{
+				{Type: 1, Code: 320, Value: 1}, // BTN_TOOL_PEN
+				{Type: 1, Code: 320, Value: 0},
+			},
+			expected: []byte{0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:  "Touchscreen without proximity reporting",
+			relay: DigitizerRelay{x: axisRange{min: -100, max: 100}, y: tablet},
+			events: []InputEvent{
+				{Type: 3, Code: 0, Value: 0},   // ABS_X
+				{Type: 1, Code: 330, Value: 1}, // BTN_TOUCH
+			},
+			expected: []byte{0x21, 0xff, 0x3f, 0, 0, 0xff, 0x0f},
+		},
+
+		{
+			name:  "Absolute mouse buttons",
+			relay: DigitizerRelay{x: tablet, y: tablet},
+			events: []InputEvent{
+				{Type: 3, Code: 0, Value: 30000}, // ABS_X, clamped to the range
+				{Type: 1, Code: 272, Value: 1},   // BTN_LEFT
+				{Type: 1, Code: 273, Value: 1},   // BTN_RIGHT
+			},
+			expected: []byte{0x23, 0xff, 0x7f, 0, 0, 0xff, 0x0f},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, event := range tt.events {
+				if report, _ := tt.relay.convertEvent(event); report != nil {
+					t.Fatalf("convertEvent(%+v) reported before SYN_REPORT: %v", event, report)
+				}
+			}
+
+			report, err := tt.relay.convertEvent(synReport)
+			if err != nil {
+				t.Fatalf("convertEvent() error = %v", err)
+			}
+			if !bytes.Equal(report, tt.expected) {
+				t.Errorf("convertEvent() = %v, want %v", report, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDigitizerRelay_TouchscreenLeavesRangeOnLift(t *testing.T) {
+	relay := &DigitizerRelay{x: axisRange{min: -100, max: 100}, y: axisRange{max: 21600}}
+	relay.convertEvent(InputEvent{Type: 3, Code: 0, Value: 0})   // ABS_X
+	relay.convertEvent(InputEvent{Type: 1, Code: 330, Value: 1}) // BTN_TOUCH
+	relay.convertEvent(synReport)
+
+	relay.convertEvent(InputEvent{Type: 1, Code: 330, Value: 0})
+	report, _ := relay.convertEvent(synReport)
+	expected := []byte{0, 0xff, 0x3f, 0, 0, 0, 0}
+	if !bytes.Equal(report, expected) {
+		t.Errorf("convertEvent() = %v, want %v", report, expected)
+	}
+}
+
+func TestDigitizerRelay_ValidateEvent(t *testing.T) {
+	relay := &DigitizerRelay{}
+
+	tests := []struct {
+		event InputEvent
+		want  bool
+	}{
+		{InputEvent{Type: 0, Code: 0}, true},    // SYN_REPORT
+		{InputEvent{Type: 3, Code: 0}, true},    // ABS_X
+		{InputEvent{Type: 3, Code: 24}, true},   // ABS_PRESSURE
+		{InputEvent{Type: 3, Code: 26}, false},  // ABS_TILT_X
+		{InputEvent{Type: 3, Code: 53}, false},  // ABS_MT_POSITION_X
+		{InputEvent{Type: 1, Code: 320}, true},  // BTN_TOOL_PEN
+		{InputEvent{Type: 1, Code: 332}, true},  // BTN_STYLUS2
+		{InputEvent{Type: 1, Code: 328}, false}, // BTN_TOOL_QUINTTAP
+		{InputEvent{Type: 1, Code: 30}, false},  // KEY_A
+		{InputEvent{Type: 2, Code: 0}, false},   // REL_X
+	}
+
+	for _, tt := range tests {
+		if got := relay.validateEvent(tt.event); got != tt.want {
+			t.Errorf("validateEvent(%+v) = %v, want %v", tt.event, got, tt.want)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package relay
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
@@ -15,92 +17,295 @@ type EventConverter interface {
 	name() string
 	validateEvent(event InputEvent) bool
 	convertEvent(event InputEvent) ([]byte, error)
+	releaseReport() []byte
 }
 
-func streamDeviceEvents(ctx context.Context, inputPath, outputPath string, eventConverter EventConverter) error {
+// reportSplitter is implemented by converters that can owe the host more than
+// one report for a single event, such as mouse motion too large for one
+// report. The stream keeps writing reports until nextReport returns nil.
+type reportSplitter interface {
+	nextReport() []byte
+}
+
+// feedbackHandler is implemented by converters that act on output or feature
+// reports the host sends to their gadget, such as the keyboard LED state. The
+// returned events are written back to the input device.
+type feedbackHandler interface {
+	feedbackEvents(report []byte) []InputEvent
+}
+
+// feedbackSwitch is implemented by feedback handlers that only need the
+// host's reports in some configurations. Their devices are opened read-only
+// and no reader is started while feedback is disabled.
+type feedbackSwitch interface {
+	feedbackEnabled() bool
+}
+
+// feedbackOf returns the converter's feedback handler, if it has one and
+// feedback is enabled for it.
+func feedbackOf(converter EventConverter) (feedbackHandler, bool) {
+	handler, ok := converter.(feedbackHandler)
+	if !ok {
+		return nil, false
+	}
+	if switcher, ok := converter.(feedbackSwitch); ok && !switcher.feedbackEnabled() {
+		return nil, false
+	}
+	return handler, true
+}
+
+// deviceProber is implemented by converters that need to know more about the
+// input device than its events tell, such as the ranges of absolute axes. It
+// is called each time the device is opened.
+type deviceProber interface {
+	probeDevice(inputFile *os.File) error
+}
+
+// route pairs an event converter with the gadget device its reports are
+// written to. One input device can feed several routes, e.g. a keyboard
+// whose media keys go to the consumer control gadget. Routes of different
+// input devices to the same gadget share a merger, which combines their
+// state; without one, reports are written as they are.
+type route struct {
+	converter  EventConverter
+	outputPath string
+	merger     *reportMerger
+	optional   bool // A failing output is dropped instead of ending the stream
+}
+
+// output is an opened route.
+type output struct {
+	converter EventConverter
+	file      *os.File
+	merger    *reportMerger
+	optional  bool
+	dropped   bool // An optional output failed and gets no more events
+}
+
+// streamDeviceEvents relays the events of an input device until ctx is done,
+// when it returns nil, or the device fails. streaming is called once the
+// device files are open. With grab set, the device is held exclusively
+// while it is relayed, so its events don't also reach the board's console
+// or other readers. The files are closed before it returns.
+func streamDeviceEvents(ctx context.Context, inputPath string, grab bool, streaming func(), routes ...route) error {
 	logger.DebugPrintf("InputEvent struct size: %d bytes", binary.Size(InputEvent{}))
 	deviceName := filepath.Base(inputPath)
 
-	for {
-		inputFile, outputFile, err := openDeviceFiles(inputPath, outputPath)
-		if err != nil {
-			return err
+	inputFile, outputs, err := openDeviceFiles(inputPath, grab, routes)
+	if err != nil {
+		return err
+	}
+	streaming()
+
+	for _, out := range outputs {
+		if handler, ok := feedbackOf(out.converter); ok {
+			target := feedbackTarget{handler: handler, inputFile: inputFile, deviceName: deviceName}
+			if out.merger != nil {
+				out.merger.addFeedbackTarget(out.converter, target)
+			}
+			go relayFeedback(out, target)
 		}
-		defer inputFile.Close()
-		defer outputFile.Close()
+	}
 
-		if err := processEvents(ctx, inputFile, outputFile, eventConverter, deviceName); err != nil {
-			logger.Printf("Error processing events for %s: %v. Reconnecting...", deviceName, err)
-			continue
+	// A read waiting for the next event would keep the stream from noticing
+	// the shutdown, so the pending read is cut short
+	stopUnblocking := context.AfterFunc(ctx, func() {
+		if err := inputFile.SetReadDeadline(time.Now()); err != nil {
+			inputFile.Close()
 		}
+	})
+	err = processEvents(ctx, inputFile, outputs, deviceName)
+	stopUnblocking()
 
-		return nil // Clean shutdown via context cancellation
+	// Whatever the device held is released, so nothing stays pressed on the
+	// host while it reconnects. Files are closed so the feedback readers of
+	// this connection stop instead of competing with the next one.
+	for _, out := range outputs {
+		if out.merger != nil {
+			out.merger.remove(out.file, out.converter)
+		} else if err != nil {
+			out.file.Write(out.converter.releaseReport())
+		}
 	}
+	closeDeviceFiles(inputFile, grab, outputs)
+	return err
 }
 
-func openDeviceFiles(inputPath, outputPath string) (*os.File, *os.File, error) {
-	inputFile, err := os.Open(inputPath)
+func openDeviceFiles(inputPath string, grab bool, routes []route) (*os.File, []output, error) {
+	// Devices are only opened for writing when feedback has to flow back
+	inputFlag := os.O_RDONLY
+	for _, rt := range routes {
+		if _, ok := feedbackOf(rt.converter); ok {
+			inputFlag = os.O_RDWR
+		}
+	}
+
+	inputFile, err := os.OpenFile(inputPath, inputFlag, 0)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to open input device %s: %v", inputPath, err)
 	}
 
-	logger.DebugPrintf("Opening output device %s", outputPath)
-	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY, 0666)
-	if err != nil {
-		inputFile.Close()
-		return nil, nil, fmt.Errorf("failed to open output device %s: %v", outputPath, err)
+	if grab {
+		if err := grabDevice(inputFile, true); err != nil {
+			inputFile.Close()
+			if errors.Is(err, syscall.EBUSY) {
+				return nil, nil, fmt.Errorf("input device %s is grabbed by another process", inputPath)
+			}
+			return nil, nil, fmt.Errorf("failed to grab input device %s: %v", inputPath, err)
+		}
+		logger.DebugPrintf("Grabbed input device %s", inputPath)
+	}
+
+	for _, rt := range routes {
+		if prober, ok := rt.converter.(deviceProber); ok {
+			if err := prober.probeDevice(inputFile); err != nil {
+				closeDeviceFiles(inputFile, grab, nil)
+				return nil, nil, fmt.Errorf("failed to probe input device %s: %v", inputPath, err)
+			}
+		}
 	}
 
-	return inputFile, outputFile, nil
+	outputs := make([]output, 0, len(routes))
+	for _, rt := range routes {
+		outputFlag := os.O_WRONLY
+		if _, ok := feedbackOf(rt.converter); ok {
+			outputFlag = os.O_RDWR
+		}
+
+		logger.DebugPrintf("Opening output device %s", rt.outputPath)
+		outputFile, err := os.OpenFile(rt.outputPath, outputFlag, 0666)
+		if err != nil {
+			closeDeviceFiles(inputFile, grab, outputs)
+			return nil, nil, fmt.Errorf("failed to open output device %s: %v", rt.outputPath, err)
+		}
+		outputs = append(outputs, output{converter: rt.converter, file: outputFile, merger: rt.merger, optional: rt.optional})
+	}
+
+	return inputFile, outputs, nil
+}
+
+// closeDeviceFiles closes a stream's files, releasing the grab on the input
+// device first. Closing the device would release it too, but only once no
+// other reference to the open file is left.
+func closeDeviceFiles(inputFile *os.File, grabbed bool, outputs []output) {
+	if grabbed {
+		if err := grabDevice(inputFile, false); err != nil {
+			logger.DebugPrintf("Failed to release input device %s: %v", inputFile.Name(), err)
+		}
+	}
+	inputFile.Close()
+	for _, out := range outputs {
+		out.file.Close()
+	}
+}
+
+// relayFeedback reads output reports from a gadget and writes the events the
+// converter derives from them to the input device, or to all devices sharing
+// the gadget. It returns once the gadget is closed.
+func relayFeedback(out output, target feedbackTarget) {
+	buf := make([]byte, 64)
+
+	for {
+		n, err := out.file.Read(buf)
+		if err != nil {
+			logger.DebugPrintf("Stopped reading output reports for %s: %v", target.deviceName, err)
+			return
+		}
+
+		logger.DebugPrintf("Output report for %s: %v", target.deviceName, buf[:n])
+
+		report := append([]byte(nil), buf[:n]...)
+		if out.merger != nil {
+			out.merger.deliverFeedback(report)
+		} else {
+			target.deliver(report)
+		}
+	}
+}
+
+func writeInputEvent(inputFile *os.File, event InputEvent) error {
+	return binary.Write(inputFile, binary.LittleEndian, &event)
 }
 
-func processEvents(ctx context.Context, inputFile, outputFile *os.File, eventConverter EventConverter, deviceName string) error {
+func processEvents(ctx context.Context, inputFile *os.File, outputs []output, deviceName string) error {
 	event := InputEvent{}
 
 	for {
-		select {
-		case <-ctx.Done():
-			logger.Printf("Relay shutdown for %s", deviceName)
-			sendReleaseEvents(outputFile)
-			return nil
-
-		default:
-			if err := binary.Read(inputFile, binary.LittleEndian, &event); err != nil {
-				return fmt.Errorf("read error: %v", err)
+		if err := binary.Read(inputFile, binary.LittleEndian, &event); err != nil {
+			if ctx.Err() != nil {
+				logger.Printf("Relay shutdown for %s", deviceName)
+				for _, out := range outputs {
+					sendReleaseEvents(out.file, out.converter.releaseReport())
+				}
+				return nil
 			}
+			return fmt.Errorf("read error: %v", err)
+		}
 
-			if !eventConverter.validateEvent(event) {
+		for i, out := range outputs {
+			if out.dropped || !out.converter.validateEvent(event) {
 				continue
 			}
 
-			logger.DebugPrintf("Read event from %s: Type=%d, Code=%d, Value=%d\n",
-				deviceName, event.Type, event.Code, event.Value)
+			logger.DebugPrintf("Read %s event from %s: Type=%d, Code=%d, Value=%d\n",
+				out.converter.name(), deviceName, event.Type, event.Code, event.Value)
 
-			if err := handleEvent(outputFile, event, eventConverter, deviceName); err != nil {
-				return err
+			if err := handleEvent(out, event); err != nil {
+				if !out.optional {
+					return err
+				}
+				// Media keys failing must not disconnect the keyboard
+				logger.Printf("Stopped relaying %s events from %s: %v", out.converter.name(), deviceName, err)
+				outputs[i].dropped = true
 			}
 		}
 	}
 }
 
-func handleEvent(outputFile *os.File, event InputEvent, eventConverter EventConverter, deviceName string) error {
+func handleEvent(out output, event InputEvent) error {
+	eventConverter := out.converter
 	report, err := eventConverter.convertEvent(event)
 	if err != nil {
 		logger.DebugPrintf("Error converting event: %v", err)
 		return nil // Non-fatal error, continue processing
 	}
 
-	if report != nil {
-		if _, err := outputFile.Write(report); err != nil {
-			return fmt.Errorf("write error: %v", err)
+	if report == nil {
+		return nil
+	}
+
+	if err := writeReport(out, report); err != nil {
+		return fmt.Errorf("write error: %v", err)
+	}
+
+	if splitter, ok := eventConverter.(reportSplitter); ok {
+		for next := splitter.nextReport(); next != nil; next = splitter.nextReport() {
+			if err := writeReport(out, next); err != nil {
+				return fmt.Errorf("write error: %v", err)
+			}
 		}
-		logger.DebugPrintf("%s event relayed", deviceName)
 	}
+
+	logger.DebugPrintf("%s event relayed", eventConverter.name())
 	return nil
 }
 
-func sendReleaseEvents(outputFile *os.File) {
-	releaseReport := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+// writeReport writes a report to the gadget, merged with the state of the
+// other devices sharing it.
+func writeReport(out output, report []byte) error {
+	if out.merger != nil {
+		return out.merger.write(out.file, out.converter, report)
+	}
+	_, err := out.file.Write(report)
+	return err
+}
+
+// eventTime returns the kernel timestamp of an event.
+func eventTime(event InputEvent) time.Duration {
+	return time.Duration(event.Time.Sec)*time.Second + time.Duration(event.Time.Usec)*time.Microsecond
+}
+
+func sendReleaseEvents(outputFile *os.File, releaseReport []byte) {
 	for i := 0; i < 3; i++ {
 		outputFile.Write(releaseReport)
 		time.Sleep(10 * time.Millisecond)
@@ -0,0 +1,76 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessEvents_DropsFailingOptionalOutput(t *testing.T) {
+	dir := t.TempDir()
+
+	// Mute, then A, pressed and released
+	var events bytes.Buffer
+	for _, event := range []InputEvent{
+		{Type: 1, Code: 113, Value: 1},
+		{Type: 1, Code: 113, Value: 0},
+		{Type: 1, Code: 30, Value: 1},
+		{Type: 1, Code: 30, Value: 0},
+	} {
+		binary.Write(&events, binary.LittleEndian, &event)
+	}
+	inputPath := filepath.Join(dir, "event3")
+	if err := os.WriteFile(inputPath, events.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	input, err := os.Open(inputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	keyboardFile, err := os.Create(filepath.Join(dir, "hidg1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyboardFile.Close()
+
+	// Writing to a read-only file fails like a broken gadget
+	consumerPath := filepath.Join(dir, "hidg2")
+	if err := os.WriteFile(consumerPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	consumerFile, err := os.Open(consumerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumerFile.Close()
+
+	outputs := []output{
+		{converter: &KeyboardRelay{}, file: keyboardFile},
+		{converter: &ConsumerRelay{}, file: consumerFile, optional: true},
+	}
+	err = processEvents(context.Background(), input, outputs, "event3")
+	if err == nil || !strings.Contains(err.Error(), "read error") {
+		t.Fatalf("processEvents() error = %v, want the read error at the end of the input", err)
+	}
+	if !outputs[1].dropped {
+		t.Error("failing consumer output was not dropped")
+	}
+
+	written, err := os.ReadFile(keyboardFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0, 0, 0x04, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(written, want) {
+		t.Errorf("keyboard reports = %v, want %v", written, want)
+	}
+}
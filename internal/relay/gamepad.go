@@ -0,0 +1,201 @@
+package relay
+
+import (
+	"os"
+
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
+)
+
+// GamepadRelay converts game controller events into gamepad reports: 16
+// buttons, a hat switch for the D-pad and six 16-bit axes (X, Y, Z, Rx, Ry,
+// Rz), matching the gamepad gadget descriptor in scripts/setup_gadgets.sh.
+// Each Linux axis is sent as the HID axis it was mapped from, so a Linux
+// host sees the same sticks and triggers as the relay. Axis values are
+// scaled from the controller's ranges to the full report range.
+type GamepadRelay struct {
+	axes   [gamepadAxisCount]axisRange // Ranges reported by the controller
+	rest   [gamepadAxisCount]int32     // Values when the controller connected, taken as the resting position
+	values [gamepadAxisCount]int32     // Latest raw values
+
+	buttons     uint16
+	hatX, hatY  int32 // D-pad, -1 (left/up), 0 or 1 (right/down)
+	dpadButtons byte  // D-pad buttons of controllers without a hat axis
+	dirty       bool
+}
+
+const gamepadReportLength = 15
+
+// Largest value of the report's axis fields, which range from -32767
+const gamepadMaxAxis = 32767
+
+// Linux axes sent to the host, in report order: ABS_X, ABS_Y, ABS_Z, ABS_RX,
+// ABS_RY and ABS_RZ, which are the codes 0 to 5
+const gamepadAxisCount = 6
+
+// Linux D-pad event codes
+const (
+	absHat0X     = 16  // ABS_HAT0X
+	absHat0Y     = 17  // ABS_HAT0Y
+	btnDpadFirst = 544 // BTN_DPAD_UP
+	btnDpadLast  = 547 // BTN_DPAD_RIGHT
+	dpadUpBit    = 1 << (544 - btnDpadFirst)
+	dpadDownBit  = 1 << (545 - btnDpadFirst)
+	dpadLeftBit  = 1 << (546 - btnDpadFirst)
+	dpadRightBit = 1 << (547 - btnDpadFirst)
+)
+
+// Linux button ranges, mapped to report buttons 1 to 16 in order. Gamepads
+// report the BTN_GAMEPAD range (BTN_SOUTH, BTN_EAST, ..., BTN_THUMBR),
+// other joysticks the BTN_JOYSTICK range (BTN_TRIGGER, ..., BTN_DEAD).
+const (
+	btnJoystickFirst = 288 // BTN_TRIGGER
+	btnJoystickLast  = 303 // BTN_DEAD
+	btnGamepadFirst  = 304 // BTN_SOUTH
+	btnGamepadLast   = 318 // BTN_THUMBR
+)
+
+// Hat switch values for D-pad directions, indexed by [hatY+1][hatX+1].
+// Directions count clockwise from north; 8 is outside the logical range and
+// means the D-pad is released.
+var hatDirections = [3][3]byte{
+	{7, 0, 1}, // Up-left, up, up-right
+	{6, 8, 2}, // Left, centered, right
+	{5, 4, 3}, // Down-left, down, down-right
+}
+
+// probeDevice reads the ranges of the controller's axes. Controllers don't
+// need to have all of them; missing axes stay centered.
+func (g *GamepadRelay) probeDevice(inputFile *os.File) error {
+	for axis := range g.axes {
+		info, err := readAbsInfo(inputFile, uint16(axis))
+		if err != nil {
+			g.axes[axis] = axisRange{}
+			continue
+		}
+		g.axes[axis] = axisRange{min: info.Minimum, max: info.Maximum}
+		g.rest[axis], g.values[axis] = info.Value, info.Value
+		logger.DebugPrintf("Gamepad axis %d: range %d to %d, value %d", axis, info.Minimum, info.Maximum, info.Value)
+	}
+	return nil
+}
+
+func (g *GamepadRelay) convertEvent(event InputEvent) ([]byte, error) {
+	logger.DebugPrintf("Gamepad event: type=%d, code=%d, value=%d", event.Type, event.Code, event.Value)
+
+	switch event.Type {
+	case 0: // EV_SYN
+		if event.Code == 0 && g.dirty { // SYN_REPORT
+			g.dirty = false
+			return g.report(), nil
+		}
+		return nil, nil
+	case 1: // EV_KEY
+		if event.Value == 2 { // Auto-repeat
+			return nil, nil
+		}
+		if event.Code >= btnDpadFirst && event.Code <= btnDpadLast {
+			bit := byte(1) << (event.Code - btnDpadFirst)
+			if event.Value == 1 {
+				g.dpadButtons |= bit
+			} else {
+				g.dpadButtons &^= bit
+			}
+		} else if button, ok := gamepadButton(event.Code); ok {
+			if event.Value == 1 {
+				g.buttons |= 1 << button
+			} else {
+				g.buttons &^= 1 << button
+			}
+		}
+	case 3: // EV_ABS
+		switch {
+		case event.Code < gamepadAxisCount:
+			g.values[event.Code] = event.Value
+		case event.Code == absHat0X:
+			g.hatX = min(max(event.Value, -1), 1)
+		case event.Code == absHat0Y:
+			g.hatY = min(max(event.Value, -1), 1)
+		}
+	}
+
+	g.dirty = true
+	return nil, nil
+}
+
+// gamepadButton returns the 0-based report button of a Linux button code.
+func gamepadButton(code uint16) (uint16, bool) {
+	switch {
+	case code >= btnGamepadFirst && code <= btnGamepadLast:
+		return code - btnGamepadFirst, true
+	case code >= btnJoystickFirst && code <= btnJoystickLast:
+		return code - btnJoystickFirst, true
+	}
+	return 0, false
+}
+
+// report builds a gamepad report: buttons, hat switch with 4 bits of
+// padding, then the axes, all little-endian.
+func (g *GamepadRelay) report() []byte {
+	report := make([]byte, 3, gamepadReportLength)
+	report[0] = byte(g.buttons)
+	report[1] = byte(g.buttons >> 8)
+	report[2] = g.hat()
+
+	for axis, rng := range g.axes {
+		var value int32 // Centered when the controller lacks the axis
+		if rng.max > rng.min {
+			value = scaleAxis(g.values[axis], rng, 2*gamepadMaxAxis) - gamepadMaxAxis
+		}
+		report = append(report, byte(value), byte(value>>8))
+	}
+
+	return report
+}
+
+// hat returns the hat switch value for the D-pad, from the hat axes or the
+// D-pad buttons, whichever the controller has.
+func (g *GamepadRelay) hat() byte {
+	x, y := g.hatX, g.hatY
+	if g.dpadButtons&dpadLeftBit != 0 {
+		x--
+	}
+	if g.dpadButtons&dpadRightBit != 0 {
+		x++
+	}
+	if g.dpadButtons&dpadUpBit != 0 {
+		y--
+	}
+	if g.dpadButtons&dpadDownBit != 0 {
+		y++
+	}
+	x, y = min(max(x, -1), 1), min(max(y, -1), 1)
+	return hatDirections[y+1][x+1]
+}
+
+func (g *GamepadRelay) validateEvent(event InputEvent) bool {
+	switch event.Type {
+	case 0: // EV_SYN
+		return true // Closes the frame
+	case 1: // EV_KEY
+		if event.Code >= btnDpadFirst && event.Code <= btnDpadLast {
+			return true
+		}
+		_, ok := gamepadButton(event.Code)
+		return ok
+	case 3: // EV_ABS
+		return event.Code < gamepadAxisCount || event.Code == absHat0X || event.Code == absHat0Y
+	default:
+		return false
+	}
+}
+
+// releaseReport releases all buttons and returns the axes to their resting
+// position, which is centered for sticks but the lowest value for triggers.
+func (g *GamepadRelay) releaseReport() []byte {
+	released := GamepadRelay{axes: g.axes, values: g.rest}
+	return released.report()
+}
+
+func (g *GamepadRelay) name() string {
+	return "gamepad"
+}
@@ -0,0 +1,95 @@
+package relay
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGamepadRelay_ConvertEvent(t *testing.T) {
+	stick := axisRange{min: -32768, max: 32767}
+	trigger := axisRange{min: 0, max: 1023}
+	// Xbox layout: sticks on X/Y and Rx/Ry, triggers on Z and Rz
+	axes := [gamepadAxisCount]axisRange{stick, stick, trigger, stick, stick, trigger}
+
+	tests := []struct {
+		name     string
+		events   []InputEvent
+		expected []byte
+	}{
+		{
+			name: "Face and shoulder buttons",
+			events: []InputEvent{
+				{Type: 1, Code: 304, Value: 1}, // BTN_SOUTH
+				{Type: 1, Code: 311, Value: 1}, // BTN_TR
+				{Type: 1, Code: 318, Value: 1}, // BTN_THUMBR
+			},
+			expected: []byte{0x81, 0x40, 8, 0, 0, 0, 0, 0x01, 0x80, 0, 0, 0, 0, 0x01, 0x80},
+		},
+		{
+			name: "Sticks and triggers",
+			events: []InputEvent{
+				{Type: 3, Code: 0, Value: 32767},  // ABS_X
+				{Type: 3, Code: 1, Value: -32768}, // ABS_Y
+				{Type: 3, Code: 5, Value: 1023},   // ABS_RZ
+			},
+			expected: []byte{0, 0, 8, 0xff, 0x7f, 0x01, 0x80, 0x01, 0x80, 0, 0, 0, 0, 0xff, 0x7f},
+		},
+		{
+			name: "D-pad hat axes",
+			events: []InputEvent{
+				{Type: 3, Code: 16, Value: 1},  // ABS_HAT0X
+				{Type: 3, Code: 17, Value: -1}, // ABS_HAT0Y
+			},
+			expected: []byte{0, 0, 1, 0, 0, 0, 0, 0x01, 0x80, 0, 0, 0, 0, 0x01, 0x80},
+		},
+		{
+			name: "D-pad buttons",
+			events: []InputEvent{
+				{Type: 1, Code: 545, Value: 1}, // BTN_DPAD_DOWN
+				{Type: 1, Code: 546, Value: 1}, // BTN_DPAD_LEFT
+			},
+			expected: []byte{0, 0, 5, 0, 0, 0, 0, 0x01, 0x80, 0, 0, 0, 0, 0x01, 0x80},
+		},
+		{
+			name: "Joystick buttons",
+			events: []InputEvent{
+				{Type: 1, Code: 288, Value: 1}, // BTN_TRIGGER
+				{Type: 1, Code: 303, Value: 1}, // BTN_DEAD
+			},
+			expected: []byte{0x01, 0x80, 8, 0, 0, 0, 0, 0x01, 0x80, 0, 0, 0, 0, 0x01, 0x80},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GamepadRelay{axes: axes}
+			for _, event := range tt.events {
+				if report, _ := g.convertEvent(event); report != nil {
+					t.Fatalf("convertEvent(%+v) reported before SYN_REPORT: %v", event, report)
+				}
+			}
+
+			report, err := g.convertEvent(synReport)
+			if err != nil {
+				t.Fatalf("convertEvent() error = %v", err)
+			}
+			if !bytes.Equal(report, tt.expected) {
+				t.Errorf("convertEvent() = %v, want %v", report, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGamepadRelay_ReleaseReport(t *testing.T) {
+	g := &GamepadRelay{
+		axes: [gamepadAxisCount]axisRange{{0, 255}, {0, 255}, {0, 1023}},
+		rest: [gamepadAxisCount]int32{128, 128, 0},
+	}
+	g.convertEvent(InputEvent{Type: 1, Code: 304, Value: 1})
+	g.convertEvent(InputEvent{Type: 3, Code: 2, Value: 1023})
+
+	want := []byte{0, 0, 8, 0x80, 0, 0x80, 0, 0x01, 0x80, 0, 0, 0, 0, 0, 0}
+	if got := g.releaseReport(); !bytes.Equal(got, want) {
+		t.Errorf("releaseReport() = %v, want %v", got, want)
+	}
+}
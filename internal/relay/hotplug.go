@@ -0,0 +1,72 @@
+package relay
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/device"
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
+)
+
+// hotplug wakes up the device lookups when the kernel reports a new input
+// device, so a device is relayed the moment it connects instead of at the
+// next poll. Polling stays in place for systems without uevents and for
+// events that are missed.
+type hotplug struct {
+	mu          sync.Mutex
+	subscribers []chan struct{}
+}
+
+// subscribe returns a channel that receives a value when input devices were
+// added. Additions while the subscriber is busy are coalesced into one.
+func (h *hotplug) subscribe() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	wake := make(chan struct{}, 1)
+	h.subscribers = append(h.subscribers, wake)
+	return wake
+}
+
+// unsubscribe stops the wake-ups of a channel returned by subscribe.
+func (h *hotplug) unsubscribe(wake <-chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, subscriber := range h.subscribers {
+		if subscriber == wake {
+			h.subscribers = append(h.subscribers[:i], h.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify wakes up all subscribers.
+func (h *hotplug) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, wake := range h.subscribers {
+		select {
+		case wake <- struct{}{}:
+		default: // A wake-up is already pending
+		}
+	}
+}
+
+// watch notifies the subscribers of every input device the kernel adds until
+// ctx is done.
+func (h *hotplug) watch(ctx context.Context) {
+	events, err := device.WatchInputDevices(ctx)
+	if err != nil {
+		logger.Printf("Hotplug events unavailable, polling for devices instead: %v", err)
+		return
+	}
+
+	for event := range events {
+		logger.DebugPrintf("Uevent: %s %s", event.Action, event.EventNode())
+		if event.Action == "add" {
+			h.notify()
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package relay
+
+import "testing"
+
+func TestHotplug_Notify(t *testing.T) {
+	h := &hotplug{}
+	first, second := h.subscribe(), h.subscribe()
+
+	// Additions while a subscriber is busy are coalesced
+	h.notify()
+	h.notify()
+
+	for i, wake := range []<-chan struct{}{first, second} {
+		select {
+		case <-wake:
+		default:
+			t.Fatalf("subscriber %d was not woken up", i)
+		}
+		select {
+		case <-wake:
+			t.Fatalf("subscriber %d was woken up twice", i)
+		default:
+		}
+	}
+}
+
+func TestHotplug_Unsubscribe(t *testing.T) {
+	h := &hotplug{}
+	first, second := h.subscribe(), h.subscribe()
+	h.unsubscribe(first)
+	h.notify()
+
+	select {
+	case <-first:
+		t.Fatal("unsubscribed channel was woken up")
+	default:
+	}
+	select {
+	case <-second:
+	default:
+		t.Fatal("remaining subscriber was not woken up")
+	}
+}
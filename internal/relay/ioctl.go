@@ -0,0 +1,108 @@
+package relay
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl request encoding from the kernel's asm-generic/ioctl.h
+const (
+	iocWrite     = 1
+	iocRead      = 2
+	iocNRShift   = 0
+	iocTypeShift = 8
+	iocSizeShift = 16
+	iocDirShift  = 30
+)
+
+// evdev ioctl numbers from linux/input.h
+const (
+	evdevIOCType = 'E'
+	eviocgkeyNR  = 0x18 // EVIOCGKEY(len)
+	eviocgabsNR  = 0x40 // EVIOCGABS(abs) is 0x40 + abs
+	eviocgrabNR  = 0x90 // EVIOCGRAB
+)
+
+// keyMax is KEY_MAX, the highest key code of the EVIOCGKEY bitmap.
+const keyMax = 0x2ff
+
+// keyState is the bitmap of held keys and buttons EVIOCGKEY returns.
+type keyState [keyMax/8 + 1]byte
+
+func (k *keyState) has(code int) bool {
+	return k[code/8]&(1<<(code%8)) != 0
+}
+
+// absInfo mirrors struct input_absinfo.
+type absInfo struct {
+	Value      int32
+	Minimum    int32
+	Maximum    int32
+	Fuzz       int32
+	Flat       int32
+	Resolution int32
+}
+
+func evdevIOC(dir, nr, size uintptr) uintptr {
+	return dir<<iocDirShift | evdevIOCType<<iocTypeShift | nr<<iocNRShift | size<<iocSizeShift
+}
+
+// ioctl runs an ioctl on an open file. It goes through the raw connection
+// rather than Fd, which would switch the file to blocking mode.
+func ioctl(file *os.File, request uintptr, arg unsafe.Pointer) error {
+	return control(file, func(fd uintptr) syscall.Errno {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+		return errno
+	})
+}
+
+// control runs a system call on the file descriptor of an open file.
+func control(file *os.File, call func(fd uintptr) syscall.Errno) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		errno = call(fd)
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// readAbsInfo queries the range of an absolute axis of an input device.
+func readAbsInfo(file *os.File, axis uint16) (absInfo, error) {
+	var info absInfo
+	request := evdevIOC(iocRead, eviocgabsNR+uintptr(axis), unsafe.Sizeof(info))
+	err := ioctl(file, request, unsafe.Pointer(&info))
+	return info, err
+}
+
+// readKeyState queries which keys and buttons of an input device are held.
+func readKeyState(file *os.File) (*keyState, error) {
+	var keys keyState
+	request := evdevIOC(iocRead, eviocgkeyNR, unsafe.Sizeof(keys))
+	err := ioctl(file, request, unsafe.Pointer(&keys))
+	return &keys, err
+}
+
+// grabDevice takes or releases exclusive access to an input device. While
+// grabbed, its events reach only this file, not the console or other readers.
+// Unlike the other evdev ioctls, EVIOCGRAB takes its argument by value.
+func grabDevice(file *os.File, grab bool) error {
+	var arg uintptr
+	if grab {
+		arg = 1
+	}
+	request := evdevIOC(iocWrite, eviocgrabNR, unsafe.Sizeof(int32(0)))
+	return control(file, func(fd uintptr) syscall.Errno {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+		return errno
+	})
+}
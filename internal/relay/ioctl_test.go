@@ -0,0 +1,27 @@
+package relay
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestEvdevIOC(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  uintptr
+		expected uintptr
+	}{
+		{"EVIOCGABS(ABS_X)", evdevIOC(iocRead, eviocgabsNR, unsafe.Sizeof(absInfo{})), 0x80184540},
+		{"EVIOCGABS(ABS_MT_POSITION_X)", evdevIOC(iocRead, eviocgabsNR+absMTPositionX, unsafe.Sizeof(absInfo{})), 0x80184575},
+		{"EVIOCGKEY(KEY_MAX)", evdevIOC(iocRead, eviocgkeyNR, unsafe.Sizeof(keyState{})), 0x80604518},
+		{"EVIOCGRAB", evdevIOC(iocWrite, eviocgrabNR, unsafe.Sizeof(int32(0))), 0x40044590},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.request != tt.expected {
+				t.Errorf("request = %#x, want %#x", tt.request, tt.expected)
+			}
+		})
+	}
+}
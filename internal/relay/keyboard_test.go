@@ -58,3 +58,162 @@ func TestKeyboardRelay_ConvertEvent(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyboardRelay_Rollover(t *testing.T) {
+	press := func(code uint16) InputEvent { return InputEvent{Type: 1, Code: code, Value: 1} }
+	release := func(code uint16) InputEvent { return InputEvent{Type: 1, Code: code, Value: 0} }
+
+	steps := []struct {
+		name       string
+		event      InputEvent
+		wantReport []byte
+	}{
+		{"press A", press(30), []byte{0, 0, 0x04, 0, 0, 0, 0, 0}},
+		{"press S while A held", press(31), []byte{0, 0, 0x04, 0x16, 0, 0, 0, 0}},
+		{"press D", press(32), []byte{0, 0, 0x04, 0x16, 0x07, 0, 0, 0}},
+		{"release A keeps S and D", release(30), []byte{0, 0, 0x16, 0x07, 0, 0, 0, 0}},
+		{"shift does not disturb keys", press(42), []byte{0x02, 0, 0x16, 0x07, 0, 0, 0, 0}},
+		{"press F", press(33), []byte{0x02, 0, 0x16, 0x07, 0x09, 0, 0, 0}},
+		{"press G", press(34), []byte{0x02, 0, 0x16, 0x07, 0x09, 0x0A, 0, 0}},
+		{"press H", press(35), []byte{0x02, 0, 0x16, 0x07, 0x09, 0x0A, 0x0B, 0}},
+		{"press J fills six slots", press(36), []byte{0x02, 0, 0x16, 0x07, 0x09, 0x0A, 0x0B, 0x0D}},
+		{"press K rolls over", press(37), []byte{0x02, 0, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01}},
+		{"repeat K stays rolled over", InputEvent{Type: 1, Code: 37, Value: 2}, []byte{0x02, 0, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01}},
+		{"release S recovers", release(31), []byte{0x02, 0, 0x07, 0x09, 0x0A, 0x0B, 0x0D, 0x0E}},
+		{"release unknown key is harmless", release(30), []byte{0x02, 0, 0x07, 0x09, 0x0A, 0x0B, 0x0D, 0x0E}},
+	}
+
+	k := new(KeyboardRelay)
+	for _, step := range steps {
+		got, err := k.convertEvent(step.event)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if !bytes.Equal(got, step.wantReport) {
+			t.Fatalf("%s: report = %v, want %v", step.name, got, step.wantReport)
+		}
+	}
+}
+
+func TestKeyboardRelay_NKRO(t *testing.T) {
+	k := &KeyboardRelay{nkro: true}
+
+	// Hold seven letters plus shift, one more than the boot report allows
+	codes := []uint16{30, 31, 32, 33, 34, 35, 36}
+	var got []byte
+	for _, code := range append([]uint16{42}, codes...) {
+		var err error
+		got, err = k.convertEvent(InputEvent{Type: 1, Code: code, Value: 1})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if len(got) != nkroReportLength {
+		t.Fatalf("report length = %d, want %d", len(got), nkroReportLength)
+	}
+
+	wantBoot := []byte{0x02, 0, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01}
+	if !bytes.Equal(got[:bootReportLength], wantBoot) {
+		t.Errorf("boot part = %v, want %v", got[:bootReportLength], wantBoot)
+	}
+
+	want := make([]byte, nkroReportLength-bootReportLength)
+	for _, usage := range []byte{0x04, 0x16, 0x07, 0x09, 0x0A, 0x0B, 0x0D} {
+		want[usage/8] |= 1 << (usage % 8)
+	}
+	if !bytes.Equal(got[bootReportLength:], want) {
+		t.Errorf("bitmap = %v, want %v", got[bootReportLength:], want)
+	}
+
+	// Releasing a key clears only its bit
+	got, _ = k.convertEvent(InputEvent{Type: 1, Code: 30, Value: 0})
+	want[0x04/8] &^= 1 << (0x04 % 8)
+	if !bytes.Equal(got[bootReportLength:], want) {
+		t.Errorf("bitmap after release = %v, want %v", got[bootReportLength:], want)
+	}
+	if !bytes.Equal(got[2:bootReportLength], []byte{0x16, 0x07, 0x09, 0x0A, 0x0B, 0x0D}) {
+		t.Errorf("boot keys after release = %v", got[2:bootReportLength])
+	}
+}
+
+func TestKeyboardRelay_FeedbackEvents(t *testing.T) {
+	k := new(KeyboardRelay)
+
+	// Caps Lock and Scroll Lock on
+	got := k.feedbackEvents([]byte{0x06})
+	want := []InputEvent{
+		{Type: 17, Code: 0, Value: 0},
+		{Type: 17, Code: 1, Value: 1},
+		{Type: 17, Code: 2, Value: 1},
+		{Type: 17, Code: 3, Value: 0},
+		{Type: 17, Code: 4, Value: 0},
+		{Type: 0, Code: 0, Value: 0},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("feedbackEvents() returned %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("feedbackEvents()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if events := k.feedbackEvents(nil); events != nil {
+		t.Errorf("feedbackEvents(nil) = %v, want nil", events)
+	}
+}
+
+func TestKeyboardRelay_ScanCodeFallback(t *testing.T) {
+	steps := []struct {
+		name       string
+		event      InputEvent
+		wantReport []byte
+	}{
+		{"scan for unknown key", InputEvent{Type: 4, Code: 4, Value: 0x00070087}, nil},
+		{"KEY_UNKNOWN press uses scan usage", InputEvent{Type: 1, Code: KEY_UNKNOWN, Value: 1}, []byte{0, 0, 0x87, 0, 0, 0, 0, 0}},
+		{"mapped key while unknown key held", InputEvent{Type: 1, Code: KEY_A, Value: 1}, []byte{0, 0, 0x87, 0x04, 0, 0, 0, 0}},
+		{"scan for release", InputEvent{Type: 4, Code: 4, Value: 0x00070087}, nil},
+		{"KEY_UNKNOWN release", InputEvent{Type: 1, Code: KEY_UNKNOWN, Value: 0}, []byte{0, 0, 0x04, 0, 0, 0, 0, 0}},
+		{"unmapped key without scan is dropped", InputEvent{Type: 1, Code: 0x2ff, Value: 1}, nil},
+		{"non-keyboard page scan", InputEvent{Type: 4, Code: 4, Value: 0x000C00E9}, nil},
+		{"unmapped key with consumer scan is dropped", InputEvent{Type: 1, Code: 0x2ff, Value: 1}, nil},
+	}
+
+	k := new(KeyboardRelay)
+	for _, step := range steps {
+		got, err := k.convertEvent(step.event)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if !bytes.Equal(got, step.wantReport) {
+			t.Fatalf("%s: report = %v, want %v", step.name, got, step.wantReport)
+		}
+	}
+}
+
+func TestKeyboardRelay_ValidateEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event InputEvent
+		want  bool
+	}{
+		{"letter key", InputEvent{Type: 1, Code: 30}, true},
+		{"media key", InputEvent{Type: 1, Code: 113}, false},
+		{"mouse button", InputEvent{Type: 1, Code: 272}, false},
+		{"gamepad button", InputEvent{Type: 1, Code: 304}, false},
+		{"key after the buttons", InputEvent{Type: 1, Code: 0x174}, true}, // KEY_PROGRAM
+		{"scan code", InputEvent{Type: 4, Code: 4}, true},
+		{"relative motion", InputEvent{Type: 2, Code: 0}, false},
+	}
+
+	k := &KeyboardRelay{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := k.validateEvent(test.event); got != test.want {
+				t.Errorf("KeyboardRelay.validateEvent() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package relay
+
+// Linux input event codes for keyboard keys, from include/uapi/linux/input-event-codes.h
+const (
+	KEY_RESERVED         = 0
+	KEY_ESC              = 1
+	KEY_1                = 2
+	KEY_2                = 3
+	KEY_3                = 4
+	KEY_4                = 5
+	KEY_5                = 6
+	KEY_6                = 7
+	KEY_7                = 8
+	KEY_8                = 9
+	KEY_9                = 10
+	KEY_0                = 11
+	KEY_MINUS            = 12
+	KEY_EQUAL            = 13
+	KEY_BACKSPACE        = 14
+	KEY_TAB              = 15
+	KEY_Q                = 16
+	KEY_W                = 17
+	KEY_E                = 18
+	KEY_R                = 19
+	KEY_T                = 20
+	KEY_Y                = 21
+	KEY_U                = 22
+	KEY_I                = 23
+	KEY_O                = 24
+	KEY_P                = 25
+	KEY_LEFTBRACE        = 26
+	KEY_RIGHTBRACE       = 27
+	KEY_ENTER            = 28
+	KEY_LEFTCTRL         = 29
+	KEY_A                = 30
+	KEY_S                = 31
+	KEY_D                = 32
+	KEY_F                = 33
+	KEY_G                = 34
+	KEY_H                = 35
+	KEY_J                = 36
+	KEY_K                = 37
+	KEY_L                = 38
+	KEY_SEMICOLON        = 39
+	KEY_APOSTROPHE       = 40
+	KEY_GRAVE            = 41
+	KEY_LEFTSHIFT        = 42
+	KEY_BACKSLASH        = 43
+	KEY_Z                = 44
+	KEY_X                = 45
+	KEY_C                = 46
+	KEY_V                = 47
+	KEY_B                = 48
+	KEY_N                = 49
+	KEY_M                = 50
+	KEY_COMMA            = 51
+	KEY_DOT              = 52
+	KEY_SLASH            = 53
+	KEY_RIGHTSHIFT       = 54
+	KEY_KPASTERISK       = 55
+	KEY_LEFTALT          = 56
+	KEY_SPACE            = 57
+	KEY_CAPSLOCK         = 58
+	KEY_F1               = 59
+	KEY_F2               = 60
+	KEY_F3               = 61
+	KEY_F4               = 62
+	KEY_F5               = 63
+	KEY_F6               = 64
+	KEY_F7               = 65
+	KEY_F8               = 66
+	KEY_F9               = 67
+	KEY_F10              = 68
+	KEY_NUMLOCK          = 69
+	KEY_SCROLLLOCK       = 70
+	KEY_KP7              = 71
+	KEY_KP8              = 72
+	KEY_KP9              = 73
+	KEY_KPMINUS          = 74
+	KEY_KP4              = 75
+	KEY_KP5              = 76
+	KEY_KP6              = 77
+	KEY_KPPLUS           = 78
+	KEY_KP1              = 79
+	KEY_KP2              = 80
+	KEY_KP3              = 81
+	KEY_KP0              = 82
+	KEY_KPDOT            = 83
+	KEY_ZENKAKUHANKAKU   = 85
+	KEY_102ND            = 86
+	KEY_F11              = 87
+	KEY_F12              = 88
+	KEY_RO               = 89
+	KEY_KATAKANA         = 90
+	KEY_HIRAGANA         = 91
+	KEY_HENKAN           = 92
+	KEY_KATAKANAHIRAGANA = 93
+	KEY_MUHENKAN         = 94
+	KEY_KPJPCOMMA        = 95
+	KEY_KPENTER          = 96
+	KEY_RIGHTCTRL        = 97
+	KEY_KPSLASH          = 98
+	KEY_SYSRQ            = 99
+	KEY_RIGHTALT         = 100
+	KEY_HOME             = 102
+	KEY_UP               = 103
+	KEY_PAGEUP           = 104
+	KEY_LEFT             = 105
+	KEY_RIGHT            = 106
+	KEY_END              = 107
+	KEY_DOWN             = 108
+	KEY_PAGEDOWN         = 109
+	KEY_INSERT           = 110
+	KEY_DELETE           = 111
+	KEY_MUTE             = 113
+	KEY_VOLUMEDOWN       = 114
+	KEY_VOLUMEUP         = 115
+	KEY_POWER            = 116
+	KEY_KPEQUAL          = 117
+	KEY_PAUSE            = 119
+	KEY_KPCOMMA          = 121
+	KEY_HANGEUL          = 122
+	KEY_HANJA            = 123
+	KEY_YEN              = 124
+	KEY_LEFTMETA         = 125
+	KEY_RIGHTMETA        = 126
+	KEY_COMPOSE          = 127
+	KEY_STOP             = 128
+	KEY_AGAIN            = 129
+	KEY_PROPS            = 130
+	KEY_UNDO             = 131
+	KEY_FRONT            = 132
+	KEY_COPY             = 133
+	KEY_OPEN             = 134
+	KEY_PASTE            = 135
+	KEY_FIND             = 136
+	KEY_CUT              = 137
+	KEY_HELP             = 138
+	KEY_KPLEFTPAREN      = 179
+	KEY_KPRIGHTPAREN     = 180
+	KEY_F13              = 183
+	KEY_F14              = 184
+	KEY_F15              = 185
+	KEY_F16              = 186
+	KEY_F17              = 187
+	KEY_F18              = 188
+	KEY_F19              = 189
+	KEY_F20              = 190
+	KEY_F21              = 191
+	KEY_F22              = 192
+	KEY_F23              = 193
+	KEY_F24              = 194
+	KEY_UNKNOWN          = 240
+)
+
+// Linux event code to HID usage ID mapping (Keyboard/Keypad page 0x07). It is
+// the inverse of the kernel's hid_keyboard table in drivers/hid/hid-input.c,
+// so a key reaches the host with the usage the keyboard itself reported.
+// Keys the kernel reaches from more than one usage (Backslash and Non-US #,
+// Delete and Clear) map to the usage found on standard keyboards. Mute,
+// volume and power keys are left to the consumer and system control reports.
+var keyCodeMap = map[uint16]byte{
+	KEY_ESC:              0x29, // Escape
+	KEY_1:                0x1E, // 1
+	KEY_2:                0x1F, // 2
+	KEY_3:                0x20, // 3
+	KEY_4:                0x21, // 4
+	KEY_5:                0x22, // 5
+	KEY_6:                0x23, // 6
+	KEY_7:                0x24, // 7
+	KEY_8:                0x25, // 8
+	KEY_9:                0x26, // 9
+	KEY_0:                0x27, // 0
+	KEY_MINUS:            0x2D, // - and _
+	KEY_EQUAL:            0x2E, // = and +
+	KEY_BACKSPACE:        0x2A, // Backspace
+	KEY_TAB:              0x2B, // Tab
+	KEY_Q:                0x14, // Q
+	KEY_W:                0x1A, // W
+	KEY_E:                0x08, // E
+	KEY_R:                0x15, // R
+	KEY_T:                0x17, // T
+	KEY_Y:                0x1C, // Y
+	KEY_U:                0x18, // U
+	KEY_I:                0x0C, // I
+	KEY_O:                0x12, // O
+	KEY_P:                0x13, // P
+	KEY_LEFTBRACE:        0x2F, // [ and {
+	KEY_RIGHTBRACE:       0x30, // ] and }
+	KEY_ENTER:            0x28, // Enter
+	KEY_LEFTCTRL:         0xE0, // Left Control
+	KEY_A:                0x04, // A
+	KEY_S:                0x16, // S
+	KEY_D:                0x07, // D
+	KEY_F:                0x09, // F
+	KEY_G:                0x0A, // G
+	KEY_H:                0x0B, // H
+	KEY_J:                0x0D, // J
+	KEY_K:                0x0E, // K
+	KEY_L:                0x0F, // L
+	KEY_SEMICOLON:        0x33, // ; and :
+	KEY_APOSTROPHE:       0x34, // ' and "
+	KEY_GRAVE:            0x35, // ` and ~
+	KEY_LEFTSHIFT:        0xE1, // Left Shift
+	KEY_BACKSLASH:        0x31, // \ and |
+	KEY_Z:                0x1D, // Z
+	KEY_X:                0x1B, // X
+	KEY_C:                0x06, // C
+	KEY_V:                0x19, // V
+	KEY_B:                0x05, // B
+	KEY_N:                0x11, // N
+	KEY_M:                0x10, // M
+	KEY_COMMA:            0x36, // , and <
+	KEY_DOT:              0x37, // . and >
+	KEY_SLASH:            0x38, // / and ?
+	KEY_RIGHTSHIFT:       0xE5, // Right Shift
+	KEY_KPASTERISK:       0x55, // Keypad *
+	KEY_LEFTALT:          0xE2, // Left Alt
+	KEY_SPACE:            0x2C, // Space
+	KEY_CAPSLOCK:         0x39, // Caps Lock
+	KEY_F1:               0x3A, // F1
+	KEY_F2:               0x3B, // F2
+	KEY_F3:               0x3C, // F3
+	KEY_F4:               0x3D, // F4
+	KEY_F5:               0x3E, // F5
+	KEY_F6:               0x3F, // F6
+	KEY_F7:               0x40, // F7
+	KEY_F8:               0x41, // F8
+	KEY_F9:               0x42, // F9
+	KEY_F10:              0x43, // F10
+	KEY_NUMLOCK:          0x53, // Num Lock
+	KEY_SCROLLLOCK:       0x47, // Scroll Lock
+	KEY_KP7:              0x5F, // Keypad 7
+	KEY_KP8:              0x60, // Keypad 8
+	KEY_KP9:              0x61, // Keypad 9
+	KEY_KPMINUS:          0x56, // Keypad -
+	KEY_KP4:              0x5C, // Keypad 4
+	KEY_KP5:              0x5D, // Keypad 5
+	KEY_KP6:              0x5E, // Keypad 6
+	KEY_KPPLUS:           0x57, // Keypad +
+	KEY_KP1:              0x59, // Keypad 1
+	KEY_KP2:              0x5A, // Keypad 2
+	KEY_KP3:              0x5B, // Keypad 3
+	KEY_KP0:              0x62, // Keypad 0
+	KEY_KPDOT:            0x63, // Keypad .
+	KEY_ZENKAKUHANKAKU:   0x94, // LANG5 (Zenkaku/Hankaku)
+	KEY_102ND:            0x64, // Non-US \ and |
+	KEY_F11:              0x44, // F11
+	KEY_F12:              0x45, // F12
+	KEY_RO:               0x87, // International1 (Ro)
+	KEY_KATAKANA:         0x92, // LANG3 (Katakana)
+	KEY_HIRAGANA:         0x93, // LANG4 (Hiragana)
+	KEY_HENKAN:           0x8A, // International4 (Henkan)
+	KEY_KATAKANAHIRAGANA: 0x88, // International2 (Katakana/Hiragana)
+	KEY_MUHENKAN:         0x8B, // International5 (Muhenkan)
+	KEY_KPJPCOMMA:        0x8C, // International6 (Keypad JP comma)
+	KEY_KPENTER:          0x58, // Keypad Enter
+	KEY_RIGHTCTRL:        0xE4, // Right Control
+	KEY_KPSLASH:          0x54, // Keypad /
+	KEY_SYSRQ:            0x46, // Print Screen
+	KEY_RIGHTALT:         0xE6, // Right Alt
+	KEY_HOME:             0x4A, // Home
+	KEY_UP:               0x52, // Up Arrow
+	KEY_PAGEUP:           0x4B, // Page Up
+	KEY_LEFT:             0x50, // Left Arrow
+	KEY_RIGHT:            0x4F, // Right Arrow
+	KEY_END:              0x4D, // End
+	KEY_DOWN:             0x51, // Down Arrow
+	KEY_PAGEDOWN:         0x4E, // Page Down
+	KEY_INSERT:           0x49, // Insert
+	KEY_DELETE:           0x4C, // Delete
+	KEY_KPEQUAL:          0x67, // Keypad =
+	KEY_PAUSE:            0x48, // Pause
+	KEY_KPCOMMA:          0x85, // Keypad ,
+	KEY_HANGEUL:          0x90, // LANG1 (Hangul/English)
+	KEY_HANJA:            0x91, // LANG2 (Hanja)
+	KEY_YEN:              0x89, // International3 (Yen)
+	KEY_LEFTMETA:         0xE3, // Left GUI
+	KEY_RIGHTMETA:        0xE7, // Right GUI
+	KEY_COMPOSE:          0x65, // Application (context menu)
+	KEY_STOP:             0x78, // Stop
+	KEY_AGAIN:            0x79, // Again
+	KEY_PROPS:            0x76, // Menu
+	KEY_UNDO:             0x7A, // Undo
+	KEY_FRONT:            0x77, // Select
+	KEY_COPY:             0x7C, // Copy
+	KEY_OPEN:             0x74, // Execute
+	KEY_PASTE:            0x7D, // Paste
+	KEY_FIND:             0x7E, // Find
+	KEY_CUT:              0x7B, // Cut
+	KEY_HELP:             0x75, // Help
+	KEY_KPLEFTPAREN:      0xB6, // Keypad (
+	KEY_KPRIGHTPAREN:     0xB7, // Keypad )
+	KEY_F13:              0x68, // F13
+	KEY_F14:              0x69, // F14
+	KEY_F15:              0x6A, // F15
+	KEY_F16:              0x6B, // F16
+	KEY_F17:              0x6C, // F17
+	KEY_F18:              0x6D, // F18
+	KEY_F19:              0x6E, // F19
+	KEY_F20:              0x6F, // F20
+	KEY_F21:              0x70, // F21
+	KEY_F22:              0x71, // F22
+	KEY_F23:              0x72, // F23
+	KEY_F24:              0x73, // F24
+}
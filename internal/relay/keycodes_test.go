@@ -0,0 +1,53 @@
+package relay
+
+import "testing"
+
+// hidKeyboard is a copy of hid_keyboard from the kernel's
+// drivers/hid/hid-input.c for usages 0x00-0xE7: the Linux key code the kernel
+// reports for each Keyboard/Keypad page usage (0 where it has none).
+var hidKeyboard = [0xE8]uint16{
+	0, 0, 0, 0, 30, 48, 46, 32, 18, 33, 34, 35, 23, 36, 37, 38,
+	50, 49, 24, 25, 16, 19, 31, 20, 22, 47, 17, 45, 21, 44, 2, 3,
+	4, 5, 6, 7, 8, 9, 10, 11, 28, 1, 14, 15, 57, 12, 13, 26,
+	27, 43, 43, 39, 40, 41, 51, 52, 53, 58, 59, 60, 61, 62, 63, 64,
+	65, 66, 67, 68, 87, 88, 99, 70, 119, 110, 102, 104, 111, 107, 109, 106,
+	105, 108, 103, 69, 98, 55, 74, 78, 96, 79, 80, 81, 75, 76, 77, 71,
+	72, 73, 82, 83, 86, 127, 116, 117, 183, 184, 185, 186, 187, 188, 189, 190,
+	191, 192, 193, 194, 134, 138, 130, 132, 128, 129, 131, 137, 133, 135, 136, 113,
+	115, 114, 0, 0, 0, 121, 0, 89, 93, 124, 92, 94, 95, 0, 0, 0,
+	122, 123, 90, 91, 85, 0, 0, 0, 0, 0, 0, 0, 111, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 179, 180, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 111, 0, 0, 0, 0, 0, 0, 0,
+	29, 42, 56, 125, 97, 54, 100, 126,
+}
+
+func TestKeyCodeMap_RoundTrip(t *testing.T) {
+	for code, usage := range keyCodeMap {
+		if int(usage) >= len(hidKeyboard) {
+			t.Errorf("key code %d maps to usage %#02x outside the keyboard page", code, usage)
+			continue
+		}
+		if got := hidKeyboard[usage]; got != code {
+			t.Errorf("key code %d maps to usage %#02x, which the kernel reports as key code %d", code, usage, got)
+		}
+	}
+}
+
+func TestKeyCodeMap_Complete(t *testing.T) {
+	for usage, code := range hidKeyboard {
+		if code == 0 {
+			continue
+		}
+		if _, handled := consumerKeyMap[code]; handled {
+			continue
+		}
+		if _, handled := systemKeyMap[code]; handled {
+			continue
+		}
+		if _, mapped := keyCodeMap[code]; !mapped {
+			t.Errorf("usage %#02x (key code %d) has no keyCodeMap entry", usage, code)
+		}
+	}
+}
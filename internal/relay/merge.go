@@ -0,0 +1,198 @@
+package relay
+
+import (
+	"os"
+	"sync"
+
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
+)
+
+// reportMerger combines the reports several input devices send to the same
+// gadget, so the host sees a single device holding whatever is held on any
+// of them: two keyboards make one keyboard report, two pointing devices add
+// their motion together. Each device keeps its own converter; the merger
+// remembers the latest report of each and merges it into every report sent.
+//
+// Output reports from the host, such as the keyboard LEDs, reach only one of
+// the devices' streams, so the merger also passes them on to all devices.
+type reportMerger struct {
+	merge func(report []byte, others [][]byte) []byte
+
+	mu       sync.Mutex
+	latest   map[EventConverter][]byte
+	feedback map[EventConverter]feedbackTarget
+}
+
+// feedbackTarget is an input device that events derived from output reports
+// are written to.
+type feedbackTarget struct {
+	handler    feedbackHandler
+	inputFile  *os.File
+	deviceName string
+}
+
+func newReportMerger(merge func(report []byte, others [][]byte) []byte) *reportMerger {
+	return &reportMerger{
+		merge:    merge,
+		latest:   make(map[EventConverter][]byte),
+		feedback: make(map[EventConverter]feedbackTarget),
+	}
+}
+
+// write merges a converter's report with the state of the other devices and
+// writes the result to the gadget.
+func (m *reportMerger) write(outputFile *os.File, converter EventConverter, report []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.latest[converter] = report
+	_, err := outputFile.Write(m.merge(report, m.others(converter)))
+	return err
+}
+
+// remove forgets a device that went away, releasing whatever it held while
+// keeping the state of the other devices.
+func (m *reportMerger) remove(outputFile *os.File, converter EventConverter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, known := m.latest[converter]
+	delete(m.latest, converter)
+	delete(m.feedback, converter)
+	if known {
+		outputFile.Write(m.merge(converter.releaseReport(), m.others(converter)))
+	}
+}
+
+// others returns the latest reports of all devices but the given one.
+func (m *reportMerger) others(converter EventConverter) [][]byte {
+	others := make([][]byte, 0, len(m.latest))
+	for c, report := range m.latest {
+		if c != converter {
+			others = append(others, report)
+		}
+	}
+	return others
+}
+
+// addFeedbackTarget registers a device to receive output reports.
+func (m *reportMerger) addFeedbackTarget(converter EventConverter, target feedbackTarget) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.feedback[converter] = target
+}
+
+// deliverFeedback passes an output report on to every registered device.
+func (m *reportMerger) deliverFeedback(report []byte) {
+	m.mu.Lock()
+	targets := make([]feedbackTarget, 0, len(m.feedback))
+	for _, target := range m.feedback {
+		targets = append(targets, target)
+	}
+	m.mu.Unlock()
+
+	for _, target := range targets {
+		target.deliver(report)
+	}
+}
+
+// deliver writes the events derived from an output report to the device.
+func (t feedbackTarget) deliver(report []byte) {
+	for _, event := range t.handler.feedbackEvents(report) {
+		if err := writeInputEvent(t.inputFile, event); err != nil {
+			logger.DebugPrintf("Error writing feedback to %s: %v", t.deviceName, err)
+			return
+		}
+	}
+}
+
+// mergeKeyboardReports merges keyboard reports: modifiers and the NKRO
+// bitmap are combined bit by bit, and the boot key arrays are joined,
+// reporting ErrorRollOver if more than six keys are held in total.
+func mergeKeyboardReports(report []byte, others [][]byte) []byte {
+	merged := append([]byte(nil), report...)
+	keys := bootKeys(report)
+	rollOver := keys == nil
+
+	for _, other := range others {
+		merged[0] |= other[0]
+		for i := bootReportLength; i < len(merged) && i < len(other); i++ {
+			merged[i] |= other[i]
+		}
+
+		otherKeys := bootKeys(other)
+		rollOver = rollOver || otherKeys == nil
+		for _, key := range otherKeys {
+			if !containsByte(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	for i := 2; i < bootReportLength; i++ {
+		merged[i] = 0
+		if rollOver || len(keys) > maxBootKeys {
+			merged[i] = errorRollOver
+		} else if i-2 < len(keys) {
+			merged[i] = keys[i-2]
+		}
+	}
+	return merged
+}
+
+// bootKeys returns the keys of a boot key array, or nil if it reports
+// ErrorRollOver.
+func bootKeys(report []byte) []byte {
+	keys := []byte{}
+	for _, key := range report[2:bootReportLength] {
+		switch key {
+		case 0:
+		case errorRollOver:
+			return nil
+		default:
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func containsByte(values []byte, value byte) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeMouseReports merges mouse reports: the buttons held on any device are
+// combined, while motion comes from the new report only, since the other
+// devices' motion has already been sent.
+func mergeMouseReports(report []byte, others [][]byte) []byte {
+	merged := append([]byte(nil), report...)
+	for _, other := range others {
+		merged[0] |= other[0]
+	}
+	return merged
+}
+
+// mergeConsumerReports keeps the new report's usage, or a usage still held
+// on another device when the new report releases its own.
+func mergeConsumerReports(report []byte, others [][]byte) []byte {
+	merged := append([]byte(nil), report...)
+	for _, other := range others {
+		if merged[1] == 0 && merged[2] == 0 {
+			merged[1], merged[2] = other[1], other[2]
+		}
+	}
+	return merged
+}
+
+// mergeSystemReports combines the system control bits of all devices.
+func mergeSystemReports(report []byte, others [][]byte) []byte {
+	merged := append([]byte(nil), report...)
+	for _, other := range others {
+		merged[1] |= other[1]
+	}
+	return merged
+}
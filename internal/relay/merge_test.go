@@ -0,0 +1,91 @@
+package relay
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestMergeKeyboardReports(t *testing.T) {
+	tests := []struct {
+		name     string
+		report   []byte
+		others   [][]byte
+		expected []byte
+	}{
+		{
+			name:     "Keys and modifiers of both keyboards",
+			report:   []byte{0x02, 0, 0x04, 0, 0, 0, 0, 0},        // Left Shift + A
+			others:   [][]byte{{0x01, 0, 0x59, 0x5a, 0, 0, 0, 0}}, // Left Ctrl + Keypad 1, 2
+			expected: []byte{0x03, 0, 0x04, 0x59, 0x5a, 0, 0, 0},
+		},
+		{
+			name:     "Same key held on both keyboards",
+			report:   []byte{0, 0, 0x04, 0, 0, 0, 0, 0},
+			others:   [][]byte{{0, 0, 0x04, 0, 0, 0, 0, 0}},
+			expected: []byte{0, 0, 0x04, 0, 0, 0, 0, 0},
+		},
+		{
+			name:     "Release keeps the other keyboard's keys",
+			report:   []byte{0, 0, 0, 0, 0, 0, 0, 0},
+			others:   [][]byte{{0, 0, 0x05, 0, 0, 0, 0, 0}},
+			expected: []byte{0, 0, 0x05, 0, 0, 0, 0, 0},
+		},
+		{
+			name:     "More than six keys in total",
+			report:   []byte{0, 0, 0x04, 0x05, 0x06, 0x07, 0, 0},
+			others:   [][]byte{{0, 0, 0x08, 0x09, 0x0a, 0, 0, 0}},
+			expected: []byte{0, 0, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01},
+		},
+		{
+			name:     "NKRO bitmaps",
+			report:   []byte{0, 0, 0x04, 0, 0, 0, 0, 0, 0x10, 0},
+			others:   [][]byte{{0, 0, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0xc0, 0xff}},
+			expected: []byte{0, 0, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0xd0, 0xff},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeKeyboardReports(tt.report, tt.others); !bytes.Equal(got, tt.expected) {
+				t.Errorf("mergeKeyboardReports() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeMouseReports(t *testing.T) {
+	got := mergeMouseReports([]byte{0x01, 5, 0xfb, 0, 0}, [][]byte{{0x02, 100, 100, 1, 0}})
+	want := []byte{0x03, 5, 0xfb, 0, 0} // Buttons of both, motion of the new report only
+	if !bytes.Equal(got, want) {
+		t.Errorf("mergeMouseReports() = %v, want %v", got, want)
+	}
+}
+
+func TestReportMerger_Remove(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "hidg")
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	defer file.Close()
+
+	merger := newReportMerger(mergeKeyboardReports)
+	first, second := &KeyboardRelay{}, &KeyboardRelay{}
+
+	merger.write(file, first, []byte{0x02, 0, 0x04, 0, 0, 0, 0, 0})
+	merger.write(file, second, []byte{0, 0, 0x05, 0, 0, 0, 0, 0})
+	merger.remove(file, first) // The first keyboard disconnects with Shift + A held
+
+	want := []byte{
+		0x02, 0, 0x04, 0, 0, 0, 0, 0,
+		0x02, 0, 0x05, 0x04, 0, 0, 0, 0,
+		0, 0, 0x05, 0, 0, 0, 0, 0,
+	}
+	got, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("written reports = %v, want %v", got, want)
+	}
+}
@@ -1,57 +1,346 @@
 package relay
 
 import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
 	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
 )
 
+// MouseRelay accumulates the events of one evdev frame and emits a single
+// report when the frame's SYN_REPORT arrives, so diagonal motion and button
+// changes made together reach the host together.
 type MouseRelay struct {
-	lastState byte
+	buttons byte  // Bitmap of held buttons, indexed by code - BTN_LEFT
+	dx, dy  int32 // Motion waiting to be reported
+	wheel   int32
+	pan     int32 // Horizontal wheel, reported as Consumer AC Pan
+	dirty   bool  // The current frame changed something worth reporting
+	wide    bool  // Send 16-bit X/Y fields, matching the "16bit" gadget descriptor
+
+	// After SYN_DROPPED every event up to the next SYN_REPORT is ignored, and
+	// the held buttons are read back from the input device.
+	dropped bool
+	input   *os.File
+
+	// Raw motion of the current frame, scaled by the pointer transform at
+	// SYN_REPORT. A nil transform passes motion through unchanged.
+	frameDX, frameDY int32
+	pointer          *pointerTransform
+
+	// High-resolution scrolling, matching the "hires" wheel descriptor. The
+	// wheels of the current frame are collected in both units and converted
+	// at SYN_REPORT, depending on whether the host enabled the multipliers.
+	hiRes                    bool
+	multipliers              *resolutionMultipliers // Shared by the gadget's relays
+	hiResSeen                bool                   // The mouse reports REL_*_HI_RES events
+	wheelDetents, panDetents int32
+	wheelHiRes, panHiRes     int32
 }
 
-func (m *MouseRelay) convertEvent(event InputEvent) ([]byte, error) {
-	var report [4]byte
+// Largest relative motion each report field can carry. The wheels are always
+// 8-bit fields; X and Y are 16-bit fields in wide reports.
+const (
+	mouseMaxDelta     = 127
+	mouseMaxWideDelta = 32767
+)
+
+// Units per wheel detent in REL_*_HI_RES events and, with the Resolution
+// Multiplier enabled, in the report's wheel fields
+const hiResPerDetent = 120
+
+// Resolution Multiplier feature report bits for the wheel and the AC Pan
+// logical collections
+const (
+	wheelMultiplierMask = 0x03
+	panMultiplierMask   = 0x0C
+)
+
+// resolutionMultipliers holds the Resolution Multiplier feature report the
+// host set on the mouse gadget. The host only sets it when it enumerates the
+// gadget, so it is saved to path to survive a restart of the relay.
+type resolutionMultipliers struct {
+	value atomic.Uint32
+	path  string // Not saved when empty
+}
 
+// loadResolutionMultipliers returns the multipliers saved at path, or none
+// if nothing was saved yet
+func loadResolutionMultipliers(path string) *resolutionMultipliers {
+	m := &resolutionMultipliers{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Printf("Failed to read resolution multiplier: %v", err)
+		}
+		return m
+	}
+	if len(data) == 1 {
+		logger.DebugPrintf("Restored resolution multiplier: %#02x", data[0])
+		m.value.Store(uint32(data[0]))
+	}
+	return m
+}
+
+func (m *resolutionMultipliers) Load() uint32 {
+	return m.value.Load()
+}
+
+func (m *resolutionMultipliers) Store(report byte) {
+	m.value.Store(uint32(report))
+	if m.path == "" {
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(m.path), 0755)
+	if err == nil {
+		err = os.WriteFile(m.path, []byte{report}, 0644)
+	}
+	if err != nil {
+		logger.Printf("Failed to save resolution multiplier: %v", err)
+	}
+}
+
+// Linux button codes accepted from mice
+const (
+	mouseFirstButton = 272 // BTN_LEFT
+	mouseLastButton  = 278 // BTN_BACK
+)
+
+// Report button bits for each Linux button, following the kernel's mapping of
+// HID buttons 4 and 5 to BTN_SIDE and BTN_EXTRA. Mice that report
+// BTN_BACK/BTN_FORWARD instead get the same back and forward buttons.
+var mouseButtonBits = [...]byte{
+	0x01, // BTN_LEFT -> Button 1
+	0x02, // BTN_RIGHT -> Button 2
+	0x04, // BTN_MIDDLE -> Button 3
+	0x08, // BTN_SIDE -> Button 4 (back)
+	0x10, // BTN_EXTRA -> Button 5 (forward)
+	0x10, // BTN_FORWARD -> Button 5
+	0x08, // BTN_BACK -> Button 4
+}
+
+func (m *MouseRelay) convertEvent(event InputEvent) ([]byte, error) {
 	logger.DebugPrintf("Mouse event: type=%d, code=%d, value=%d, time=%v", event.Type, event.Code, event.Value, event.Time)
 
+	if m.dropped && (event.Type != 0 || event.Code != 0) {
+		return nil, nil
+	}
+
 	switch event.Type {
+	case 0: // EV_SYN
+		switch event.Code {
+		case 0: // SYN_REPORT
+			if m.dropped {
+				m.dropped = false
+				m.resyncButtons()
+			}
+			m.flushMotion(eventTime(event))
+			m.flushWheels()
+			if !m.dirty {
+				return nil, nil
+			}
+			m.dirty = false
+			return m.report(), nil
+		case 3: // SYN_DROPPED
+			// The kernel lost events; discard the partial frame and
+			// everything up to the end of the next one
+			m.dx, m.dy, m.wheel, m.pan, m.dirty = 0, 0, 0, 0, false
+			m.frameDX, m.frameDY = 0, 0
+			m.wheelDetents, m.panDetents, m.wheelHiRes, m.panHiRes = 0, 0, 0, 0
+			m.dropped = true
+		}
 	case 1: // EV_KEY
-		if event.Code >= 272 && event.Code <= 276 {
-			buttonBit := event.Code - 272 // Convert to 0-based index
+		if event.Code >= mouseFirstButton && event.Code <= mouseLastButton {
+			buttonBit := event.Code - mouseFirstButton // Convert to 0-based index
 
 			if event.Value == 1 { // Button press
-				m.lastState |= 1 << buttonBit
+				m.buttons |= 1 << buttonBit
 			} else if event.Value == 0 { // Button release
-				m.lastState &^= 1 << buttonBit
+				m.buttons &^= 1 << buttonBit
 			}
-			report[0] = m.lastState
-			return report[:], nil
+			m.dirty = true
 		}
 	case 2: // EV_REL
-		report[0] = m.lastState
 		switch event.Code {
 		case 0: // X axis
-			report[1] = byte(event.Value)
+			m.frameDX += event.Value
 		case 1: // Y axis
-			report[2] = byte(event.Value)
+			m.frameDY += event.Value
+		case 6: // Horizontal wheel
+			m.panDetents += event.Value
 		case 8: // Wheel
-			report[3] = byte(event.Value)
+			m.wheelDetents += event.Value
+		case 11: // REL_WHEEL_HI_RES
+			m.wheelHiRes += event.Value
+			m.hiResSeen = true
+		case 12: // REL_HWHEEL_HI_RES
+			m.panHiRes += event.Value
+			m.hiResSeen = true
 		}
-		return report[:], nil
 	}
 
-	// For any other event type, still return the current state
-	report[0] = m.lastState
-	return report[:], nil
+	return nil, nil
+}
+
+// flushMotion adds the pointer motion of the frame to the pending motion,
+// after passing it through the pointer transform.
+func (m *MouseRelay) flushMotion(timestamp time.Duration) {
+	if m.frameDX == 0 && m.frameDY == 0 {
+		return
+	}
+
+	dx, dy := m.frameDX, m.frameDY
+	m.frameDX, m.frameDY = 0, 0
+	if m.pointer != nil {
+		dx, dy = m.pointer.apply(dx, dy, timestamp)
+	}
+
+	if dx != 0 || dy != 0 {
+		m.dx += dx
+		m.dy += dy
+		m.dirty = true
+	}
+}
+
+// flushWheels adds the wheel movement of the frame to the pending motion, in
+// the unit the host currently expects for each wheel.
+func (m *MouseRelay) flushWheels() {
+	var multipliers uint32
+	if m.multipliers != nil {
+		multipliers = m.multipliers.Load()
+	}
+	wheel := m.scrollDelta(m.wheelDetents, m.wheelHiRes, multipliers&wheelMultiplierMask != 0)
+	pan := m.scrollDelta(m.panDetents, m.panHiRes, multipliers&panMultiplierMask != 0)
+	m.wheelDetents, m.panDetents, m.wheelHiRes, m.panHiRes = 0, 0, 0, 0
+
+	if wheel != 0 || pan != 0 {
+		m.wheel += wheel
+		m.pan += pan
+		m.dirty = true
+	}
+}
+
+// scrollDelta picks the wheel value for a report. Until the host enables the
+// Resolution Multiplier it gets detents; afterwards it gets hi-res units,
+// scaled up from detents for mice that don't report hi-res events.
+func (m *MouseRelay) scrollDelta(detents, hiRes int32, multiplied bool) int32 {
+	switch {
+	case !m.hiRes || !multiplied:
+		return detents
+	case m.hiResSeen:
+		return hiRes
+	default:
+		return detents * hiResPerDetent
+	}
+}
+
+// probeDevice keeps the input device to read the held buttons from after
+// the kernel dropped events.
+func (m *MouseRelay) probeDevice(inputFile *os.File) error {
+	m.input = inputFile
+	m.dropped = false
+	return nil
+}
+
+// resyncButtons replaces the button state, which lost events may have left
+// stale, with the buttons the input device holds. Without a device to ask,
+// every button is taken as released so none stays stuck on the host.
+func (m *MouseRelay) resyncButtons() {
+	buttons := m.buttons
+	m.buttons = 0
+	if m.input != nil {
+		keys, err := readKeyState(m.input)
+		if err != nil {
+			logger.DebugPrintf("Failed to read mouse button state: %v", err)
+		} else {
+			for code := mouseFirstButton; code <= mouseLastButton; code++ {
+				if keys.has(code) {
+					m.buttons |= 1 << (code - mouseFirstButton)
+				}
+			}
+		}
+	}
+	if m.buttons != buttons {
+		m.dirty = true
+	}
+}
+
+// feedbackEnabled reports whether the host's feature reports matter, which
+// is only the case for the hi-res wheel descriptor.
+func (m *MouseRelay) feedbackEnabled() bool {
+	return m.hiRes
+}
+
+// feedbackEvents records the Resolution Multiplier feature report the host
+// sends to the gadget. Nothing is written back to the mouse.
+func (m *MouseRelay) feedbackEvents(report []byte) []InputEvent {
+	if len(report) > 0 && m.multipliers != nil {
+		logger.DebugPrintf("Resolution multiplier feature report: %#02x", report[0])
+		m.multipliers.Store(report[0])
+	}
+	return nil
+}
+
+// report builds a mouse report from the accumulated motion: buttons, X, Y,
+// wheel and horizontal wheel, with X and Y taking two little-endian bytes
+// each in wide reports. Motion that doesn't fit into the report's fields
+// stays pending, so the total distance is preserved instead of wrapping
+// around.
+func (m *MouseRelay) report() []byte {
+	buttons := m.buttonBits()
+
+	if m.wide {
+		x, y := takeDelta(&m.dx, mouseMaxWideDelta), takeDelta(&m.dy, mouseMaxWideDelta)
+		wheel, pan := takeDelta(&m.wheel, mouseMaxDelta), takeDelta(&m.pan, mouseMaxDelta)
+		return []byte{buttons, byte(x), byte(x >> 8), byte(y), byte(y >> 8), byte(wheel), byte(pan)}
+	}
+
+	x, y := takeDelta(&m.dx, mouseMaxDelta), takeDelta(&m.dy, mouseMaxDelta)
+	wheel, pan := takeDelta(&m.wheel, mouseMaxDelta), takeDelta(&m.pan, mouseMaxDelta)
+	return []byte{buttons, byte(x), byte(y), byte(wheel), byte(pan)}
+}
+
+// buttonBits returns the report's button byte for the held buttons.
+func (m *MouseRelay) buttonBits() byte {
+	var bits byte
+	for i, bit := range mouseButtonBits {
+		if m.buttons&(1<<i) != 0 {
+			bits |= bit
+		}
+	}
+	return bits
+}
+
+// nextReport returns a further report while motion from the last frame is
+// still pending, so a fast flick is split across several reports.
+func (m *MouseRelay) nextReport() []byte {
+	if m.dx == 0 && m.dy == 0 && m.wheel == 0 && m.pan == 0 {
+		return nil
+	}
+	return m.report()
+}
+
+// takeDelta removes as much of a pending delta as fits into one report field.
+func takeDelta(pending *int32, limit int32) int32 {
+	delta := min(max(*pending, -limit), limit)
+	*pending -= delta
+	return delta
 }
 
 func (m *MouseRelay) validateEvent(event InputEvent) bool {
 	switch event.Type {
 	case 0: // EV_SYN
-		return false
+		return true // Closes the frame
 	case 1: // EV_KEY
-		return event.Code >= 272 && event.Code <= 276
+		return event.Code >= mouseFirstButton && event.Code <= mouseLastButton
 	case 2: // EV_REL
-		return event.Code <= 8
+		switch event.Code {
+		case 0, 1, 6, 8, 11, 12: // X, Y, HWHEEL, WHEEL, WHEEL_HI_RES, HWHEEL_HI_RES
+			return true
+		}
+		return false
 	case 4: // EV_MSC
 		return false // Explicitly ignore these events
 	default:
@@ -60,6 +349,13 @@ func (m *MouseRelay) validateEvent(event InputEvent) bool {
 	}
 }
 
+func (m *MouseRelay) releaseReport() []byte {
+	if m.wide {
+		return make([]byte, 7)
+	}
+	return make([]byte, 5)
+}
+
 func (m *MouseRelay) name() string {
 	return "mouse"
 }
@@ -1,54 +1,132 @@
 package relay
 
 import (
+	"bytes"
+	"path/filepath"
 	"testing"
 )
 
+var synReport = InputEvent{Type: 0, Code: 0, Value: 0}
+
 func TestMouseRelay_ConvertEvent(t *testing.T) {
 	tests := []struct {
 		name       string
-		event      InputEvent
+		events     []InputEvent // The last event is expected to produce the report
 		wantReport []byte
 		wantErr    bool
 	}{
 		{
 			name: "left button press",
-			event: InputEvent{
-				Type:  1,   // EV_KEY
-				Code:  272, // BTN_LEFT
-				Value: 1,
+			events: []InputEvent{
+				{Type: 1, Code: 272, Value: 1}, // BTN_LEFT
+				synReport,
 			},
-			wantReport: []byte{0x01, 0, 0, 0}, // First bit set for left button
+			wantReport: []byte{0x01, 0, 0, 0, 0}, // First bit set for left button
 			wantErr:    false,
 		},
 		{
 			name: "mouse move right",
-			event: InputEvent{
-				Type:  2, // EV_REL
-				Code:  0, // REL_X
-				Value: 10,
+			events: []InputEvent{
+				{Type: 2, Code: 0, Value: 10}, // REL_X
+				synReport,
 			},
-			wantReport: []byte{0, 10, 0, 0},
+			wantReport: []byte{0, 10, 0, 0, 0},
 			wantErr:    false,
 		},
 		{
 			name: "mouse move down",
-			event: InputEvent{
-				Type:  2, // EV_REL
-				Code:  1, // REL_Y
-				Value: 5,
+			events: []InputEvent{
+				{Type: 2, Code: 1, Value: 5}, // REL_Y
+				synReport,
 			},
-			wantReport: []byte{0, 0, 5, 0},
+			wantReport: []byte{0, 0, 5, 0, 0},
 			wantErr:    false,
 		},
 		{
 			name: "scroll wheel",
-			event: InputEvent{
-				Type:  2, // EV_REL
-				Code:  8, // REL_WHEEL
-				Value: 1,
+			events: []InputEvent{
+				{Type: 2, Code: 8, Value: 1}, // REL_WHEEL
+				synReport,
+			},
+			wantReport: []byte{0, 0, 0, 1, 0},
+			wantErr:    false,
+		},
+		{
+			name: "diagonal move in one frame",
+			events: []InputEvent{
+				{Type: 2, Code: 0, Value: 3},
+				{Type: 2, Code: 1, Value: -4},
+				synReport,
+			},
+			wantReport: []byte{0, 3, 0xFC, 0, 0},
+			wantErr:    false,
+		},
+		{
+			name: "drag with button and motion",
+			events: []InputEvent{
+				{Type: 1, Code: 272, Value: 1},
+				{Type: 2, Code: 0, Value: 2},
+				{Type: 2, Code: 0, Value: 2},
+				synReport,
+			},
+			wantReport: []byte{0x01, 4, 0, 0, 0},
+			wantErr:    false,
+		},
+		{
+			name: "side and extra buttons",
+			events: []InputEvent{
+				{Type: 1, Code: 275, Value: 1}, // BTN_SIDE
+				{Type: 1, Code: 276, Value: 1}, // BTN_EXTRA
+				synReport,
+			},
+			wantReport: []byte{0x18, 0, 0, 0, 0},
+			wantErr:    false,
+		},
+		{
+			name: "back button",
+			events: []InputEvent{
+				{Type: 1, Code: 278, Value: 1}, // BTN_BACK
+				synReport,
+			},
+			wantReport: []byte{0x08, 0, 0, 0, 0},
+			wantErr:    false,
+		},
+		{
+			name: "horizontal scroll",
+			events: []InputEvent{
+				{Type: 2, Code: 6, Value: -2}, // REL_HWHEEL
+				synReport,
+			},
+			wantReport: []byte{0, 0, 0, 0, 0xFE},
+			wantErr:    false,
+		},
+		{
+			name:       "empty frame",
+			events:     []InputEvent{synReport},
+			wantReport: nil,
+			wantErr:    false,
+		},
+		{
+			name: "dropped frame is discarded",
+			events: []InputEvent{
+				{Type: 2, Code: 0, Value: 7},
+				{Type: 0, Code: 3, Value: 0}, // SYN_DROPPED
+				synReport,
 			},
-			wantReport: []byte{0, 0, 0, 1},
+			wantReport: nil,
+			wantErr:    false,
+		},
+		{
+			name: "events up to the SYN_REPORT after SYN_DROPPED are ignored",
+			events: []InputEvent{
+				{Type: 0, Code: 3, Value: 0}, // SYN_DROPPED
+				{Type: 2, Code: 0, Value: 7},
+				{Type: 1, Code: 272, Value: 1},
+				synReport,
+				{Type: 2, Code: 0, Value: 5},
+				synReport,
+			},
+			wantReport: []byte{0, 5, 0, 0, 0},
 			wantErr:    false,
 		},
 	}
@@ -56,7 +134,15 @@ func TestMouseRelay_ConvertEvent(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m := &MouseRelay{}
-			report, err := m.convertEvent(tt.event)
+
+			var report []byte
+			var err error
+			for i, event := range tt.events {
+				report, err = m.convertEvent(event)
+				if i < len(tt.events)-1 && report != nil {
+					t.Fatalf("MouseRelay.convertEvent() reported %v before the end of the frame", report)
+				}
+			}
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("MouseRelay.convertEvent() error = %v, wantErr %v", err, tt.wantErr)
@@ -108,7 +194,7 @@ func TestMouseRelay_ValidateEvent(t *testing.T) {
 				Code:  0,
 				Value: 0,
 			},
-			want: false,
+			want: true,
 		},
 		{
 			name: "invalid event type",
@@ -130,3 +216,175 @@ func TestMouseRelay_ValidateEvent(t *testing.T) {
 		})
 	}
 }
+
+func TestMouseRelay_SplitsLargeDeltas(t *testing.T) {
+	m := &MouseRelay{}
+
+	m.convertEvent(InputEvent{Type: 2, Code: 0, Value: 300})  // REL_X
+	m.convertEvent(InputEvent{Type: 2, Code: 1, Value: -130}) // REL_Y
+	report, _ := m.convertEvent(synReport)
+
+	reports := [][]byte{report}
+	for next := m.nextReport(); next != nil; next = m.nextReport() {
+		reports = append(reports, next)
+	}
+
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want 3: %v", len(reports), reports)
+	}
+
+	var totalX, totalY int
+	for _, r := range reports {
+		totalX += int(int8(r[1]))
+		totalY += int(int8(r[2]))
+	}
+	if totalX != 300 || totalY != -130 {
+		t.Errorf("total motion = (%d, %d), want (300, -130)", totalX, totalY)
+	}
+	if int8(reports[0][1]) != 127 || int8(reports[0][2]) != -127 {
+		t.Errorf("first report = %v, want motion clamped to (127, -127)", reports[0])
+	}
+}
+
+func TestMouseRelay_WideReport(t *testing.T) {
+	m := &MouseRelay{wide: true}
+
+	m.convertEvent(InputEvent{Type: 1, Code: 273, Value: 1})  // BTN_RIGHT
+	m.convertEvent(InputEvent{Type: 2, Code: 0, Value: 300})  // REL_X
+	m.convertEvent(InputEvent{Type: 2, Code: 1, Value: -130}) // REL_Y
+	m.convertEvent(InputEvent{Type: 2, Code: 8, Value: -1})   // REL_WHEEL
+	report, _ := m.convertEvent(synReport)
+
+	want := []byte{0x02, 0x2C, 0x01, 0x7E, 0xFF, 0xFF, 0}
+	if !bytes.Equal(report, want) {
+		t.Errorf("wide report = %v, want %v", report, want)
+	}
+	if next := m.nextReport(); next != nil {
+		t.Errorf("wide report left motion pending: %v", next)
+	}
+}
+
+func TestMouseRelay_HiResWheel(t *testing.T) {
+	hiResFrame := []InputEvent{
+		{Type: 2, Code: 11, Value: 60},  // REL_WHEEL_HI_RES, half a detent
+		{Type: 2, Code: 12, Value: -30}, // REL_HWHEEL_HI_RES
+		synReport,
+	}
+	detentFrame := []InputEvent{
+		{Type: 2, Code: 11, Value: 60},
+		{Type: 2, Code: 8, Value: 1}, // REL_WHEEL completes the detent
+		synReport,
+	}
+
+	tests := []struct {
+		name        string
+		hiRes       bool
+		multipliers byte
+		frames      [][]InputEvent
+		wantReports [][]byte
+	}{
+		{
+			name:        "mode disabled sends detents",
+			hiRes:       false,
+			multipliers: 0x05,
+			frames:      [][]InputEvent{hiResFrame, detentFrame},
+			wantReports: [][]byte{nil, {0, 0, 0, 1, 0}},
+		},
+		{
+			name:        "host without multiplier gets detents",
+			hiRes:       true,
+			multipliers: 0,
+			frames:      [][]InputEvent{hiResFrame, detentFrame},
+			wantReports: [][]byte{nil, {0, 0, 0, 1, 0}},
+		},
+		{
+			name:        "host with multipliers gets hi-res values",
+			hiRes:       true,
+			multipliers: 0x05,
+			frames:      [][]InputEvent{hiResFrame, detentFrame},
+			wantReports: [][]byte{{0, 0, 0, 60, 0xE2}, {0, 0, 0, 60, 0}},
+		},
+		{
+			name:        "only the vertical multiplier enabled",
+			hiRes:       true,
+			multipliers: 0x01,
+			frames:      [][]InputEvent{hiResFrame},
+			wantReports: [][]byte{{0, 0, 0, 60, 0}},
+		},
+		{
+			name:        "detent-only mouse is scaled up",
+			hiRes:       true,
+			multipliers: 0x05,
+			frames:      [][]InputEvent{{{Type: 2, Code: 8, Value: -1}, synReport}},
+			wantReports: [][]byte{{0, 0, 0, 0x88, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MouseRelay{hiRes: tt.hiRes, multipliers: &resolutionMultipliers{}}
+			m.feedbackEvents([]byte{tt.multipliers})
+
+			for i, frame := range tt.frames {
+				var report []byte
+				for _, event := range frame {
+					report, _ = m.convertEvent(event)
+				}
+				if !bytes.Equal(report, tt.wantReports[i]) {
+					t.Errorf("frame %d report = %v, want %v", i, report, tt.wantReports[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResolutionMultipliers_Persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay", "mouse-multipliers")
+
+	if got := loadResolutionMultipliers(path).Load(); got != 0 {
+		t.Errorf("Load() before anything was saved = %#02x, want 0", got)
+	}
+
+	m := &MouseRelay{hiRes: true, multipliers: loadResolutionMultipliers(path)}
+	m.feedbackEvents([]byte{0x05})
+
+	if got := loadResolutionMultipliers(path).Load(); got != 0x05 {
+		t.Errorf("Load() after a restart = %#02x, want 0x05", got)
+	}
+}
+
+func TestMouseRelay_FeedbackOnlyWithHiRes(t *testing.T) {
+	if _, ok := feedbackOf(&MouseRelay{}); ok {
+		t.Error("feedbackOf() enabled feedback for a mouse without hi-res scrolling")
+	}
+	if _, ok := feedbackOf(&MouseRelay{hiRes: true}); !ok {
+		t.Error("feedbackOf() disabled feedback for a mouse with hi-res scrolling")
+	}
+}
+
+func TestRelay_MouseMultipliersSurviveReconnect(t *testing.T) {
+	r := NewRelay(Config{MouseHiRes: true})
+	r.newMouseRelay().feedbackEvents([]byte{0x05})
+
+	m := r.newMouseRelay()
+	m.convertEvent(InputEvent{Type: 2, Code: 8, Value: 1}) // REL_WHEEL
+	got, _ := m.convertEvent(synReport)
+	want := []byte{0x00, 0x00, 0x00, hiResPerDetent, 0x00}
+	if !bytes.Equal(got, want) {
+		t.Errorf("report after reconnect = %v, want %v", got, want)
+	}
+}
+
+func TestMouseRelay_DroppedEventsReleaseStaleButtons(t *testing.T) {
+	m := &MouseRelay{}
+	m.convertEvent(InputEvent{Type: 1, Code: 272, Value: 1}) // BTN_LEFT
+	m.convertEvent(synReport)
+
+	// The release was lost; without a device to ask, the button is released
+	m.convertEvent(InputEvent{Type: 0, Code: 3, Value: 0}) // SYN_DROPPED
+	report, _ := m.convertEvent(synReport)
+	want := []byte{0, 0, 0, 0, 0}
+	if !bytes.Equal(report, want) {
+		t.Errorf("report after SYN_DROPPED = %v, want %v", report, want)
+	}
+}
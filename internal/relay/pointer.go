@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PointerConfig describes how relative pointer motion is scaled before it is
+// sent to the host. Since the relay is the mouse as far as the host is
+// concerned, tuning it here makes the pointer feel the same on every host.
+type PointerConfig struct {
+	Sensitivity  float64 // Constant multiplier applied after acceleration, 0 means 1
+	Acceleration string  // "flat" (default), "adaptive" or "curve"
+	Curve        string  // Points of the "curve" profile as speed:factor pairs, e.g. "0:1,1:1.5,4:3"
+}
+
+// Pointer acceleration profiles
+const (
+	AccelFlat     = "flat"
+	AccelAdaptive = "adaptive"
+	AccelCurve    = "curve"
+)
+
+// The adaptive profile follows libinput's: slow motion is slightly
+// decelerated for precision, motion above the threshold speed is accelerated
+// linearly up to a maximum factor. Speeds are in counts per millisecond.
+const (
+	adaptiveThreshold = 0.4
+	adaptiveIncline   = 1.1
+	adaptiveMaxFactor = 3.0
+	adaptiveMinFactor = 0.3
+)
+
+// Frame intervals used to estimate pointer speed. After a pause, or when the
+// timestamps are unusable, motion is assumed to span a typical frame.
+const (
+	minFrameInterval     = time.Millisecond
+	maxFrameInterval     = 100 * time.Millisecond
+	defaultFrameInterval = 10 * time.Millisecond
+)
+
+// curvePoint is a point of a user-defined acceleration curve.
+type curvePoint struct {
+	speed  float64 // Counts per millisecond
+	factor float64
+}
+
+// pointerTransform scales relative motion by a sensitivity and an
+// acceleration profile. Fractions of a count that can't be reported yet are
+// kept and added to the next frame, so slow movement isn't lost.
+type pointerTransform struct {
+	sensitivity float64
+	profile     func(speed float64) float64
+
+	lastTime   time.Duration
+	remX, remY float64
+}
+
+func newPointerTransform(config PointerConfig) (*pointerTransform, error) {
+	p := &pointerTransform{sensitivity: config.Sensitivity}
+	if p.sensitivity == 0 {
+		p.sensitivity = 1
+	}
+	if !isNonNegative(p.sensitivity) {
+		return nil, fmt.Errorf("invalid pointer sensitivity: %v", config.Sensitivity)
+	}
+
+	switch config.Acceleration {
+	case "", AccelFlat:
+		p.profile = flatProfile
+	case AccelAdaptive:
+		p.profile = adaptiveProfile
+	case AccelCurve:
+		points, err := parseCurve(config.Curve)
+		if err != nil {
+			return nil, err
+		}
+		p.profile = curveProfile(points)
+	default:
+		return nil, fmt.Errorf("unknown pointer acceleration profile: %q", config.Acceleration)
+	}
+
+	return p, nil
+}
+
+// apply transforms the motion of one frame reported at the given time.
+func (p *pointerTransform) apply(dx, dy int32, timestamp time.Duration) (int32, int32) {
+	interval := timestamp - p.lastTime
+	if p.lastTime == 0 || interval < minFrameInterval || interval > maxFrameInterval {
+		interval = defaultFrameInterval
+	}
+	p.lastTime = timestamp
+
+	distance := math.Hypot(float64(dx), float64(dy))
+	speed := distance / (float64(interval) / float64(time.Millisecond))
+	factor := p.sensitivity * p.profile(speed)
+
+	x := float64(dx)*factor + p.remX
+	y := float64(dy)*factor + p.remY
+	outX, outY := math.Trunc(x), math.Trunc(y)
+	p.remX, p.remY = x-outX, y-outY
+
+	return int32(outX), int32(outY)
+}
+
+func flatProfile(speed float64) float64 {
+	return 1
+}
+
+func adaptiveProfile(speed float64) float64 {
+	if speed < adaptiveThreshold {
+		// Decelerate very slow motion, reaching 1 at the threshold
+		return adaptiveMinFactor + (1-adaptiveMinFactor)*speed/adaptiveThreshold
+	}
+	return min(1+(speed-adaptiveThreshold)*adaptiveIncline, adaptiveMaxFactor)
+}
+
+// curveProfile interpolates linearly between the points of a curve and keeps
+// the factor of the first and last point outside of it.
+func curveProfile(points []curvePoint) func(speed float64) float64 {
+	return func(speed float64) float64 {
+		if speed <= points[0].speed {
+			return points[0].factor
+		}
+		for i := 1; i < len(points); i++ {
+			if speed <= points[i].speed {
+				prev, next := points[i-1], points[i]
+				t := (speed - prev.speed) / (next.speed - prev.speed)
+				return prev.factor + t*(next.factor-prev.factor)
+			}
+		}
+		return points[len(points)-1].factor
+	}
+}
+
+// parseCurve parses comma-separated speed:factor pairs.
+func parseCurve(curve string) ([]curvePoint, error) {
+	if strings.TrimSpace(curve) == "" {
+		return nil, fmt.Errorf("pointer acceleration curve is empty")
+	}
+
+	var points []curvePoint
+	for _, pair := range strings.Split(curve, ",") {
+		speedText, factorText, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid curve point %q, expected speed:factor", pair)
+		}
+
+		speed, err := strconv.ParseFloat(speedText, 64)
+		if err != nil || !isNonNegative(speed) {
+			return nil, fmt.Errorf("invalid curve speed %q", speedText)
+		}
+		factor, err := strconv.ParseFloat(factorText, 64)
+		if err != nil || !isNonNegative(factor) {
+			return nil, fmt.Errorf("invalid curve factor %q", factorText)
+		}
+
+		points = append(points, curvePoint{speed: speed, factor: factor})
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i].speed < points[j].speed })
+	for i := 1; i < len(points); i++ {
+		if points[i].speed == points[i-1].speed {
+			return nil, fmt.Errorf("duplicate curve speed %v", points[i].speed)
+		}
+	}
+
+	return points, nil
+}
+
+// isNonNegative reports whether v is a finite number >= 0.
+func isNonNegative(v float64) bool {
+	return v >= 0 && !math.IsInf(v, 1)
+}
@@ -0,0 +1,89 @@
+package relay
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPointerTransform_CarriesRemainder(t *testing.T) {
+	p, err := newPointerTransform(PointerConfig{Sensitivity: 0.4})
+	if err != nil {
+		t.Fatalf("newPointerTransform() error = %v", err)
+	}
+
+	// Ten frames of one count each must add up to four counts
+	var totalX, totalY int32
+	for i := 0; i < 10; i++ {
+		x, y := p.apply(1, -1, time.Duration(i)*8*time.Millisecond)
+		totalX += x
+		totalY += y
+	}
+
+	if totalX != 4 || totalY != -4 {
+		t.Errorf("total motion = (%d, %d), want (4, -4)", totalX, totalY)
+	}
+}
+
+func TestPointerTransform_Adaptive(t *testing.T) {
+	p, err := newPointerTransform(PointerConfig{Acceleration: AccelAdaptive})
+	if err != nil {
+		t.Fatalf("newPointerTransform() error = %v", err)
+	}
+
+	// 10 counts in 10ms is exactly 1 count/ms
+	p.apply(0, 0, 10*time.Millisecond)
+	x, _ := p.apply(10, 0, 20*time.Millisecond)
+	want := int32(10 * adaptiveProfile(1))
+	if x != want {
+		t.Errorf("accelerated motion = %d, want %d", x, want)
+	}
+
+	// A fast flick is capped at the maximum factor
+	x, _ = p.apply(100, 0, 30*time.Millisecond)
+	if x != 100*adaptiveMaxFactor {
+		t.Errorf("fast motion = %d, want %v", x, 100*adaptiveMaxFactor)
+	}
+}
+
+func TestCurveProfile(t *testing.T) {
+	points, err := parseCurve("4:3, 0:1,1:1.5")
+	if err != nil {
+		t.Fatalf("parseCurve() error = %v", err)
+	}
+	profile := curveProfile(points)
+
+	tests := []struct {
+		speed float64
+		want  float64
+	}{
+		{0, 1},
+		{0.5, 1.25},
+		{1, 1.5},
+		{2.5, 2.25},
+		{10, 3},
+	}
+
+	for _, test := range tests {
+		if got := profile(test.speed); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("profile(%v) = %v, want %v", test.speed, got, test.want)
+		}
+	}
+}
+
+func TestNewPointerTransform_Invalid(t *testing.T) {
+	configs := []PointerConfig{
+		{Sensitivity: -1},
+		{Acceleration: "turbo"},
+		{Acceleration: AccelCurve},
+		{Acceleration: AccelCurve, Curve: "1:2,oops"},
+		{Acceleration: AccelCurve, Curve: "1:2,1:3"},
+		{Acceleration: AccelCurve, Curve: "NaN:1"},
+	}
+
+	for _, config := range configs {
+		if _, err := newPointerTransform(config); err == nil {
+			t.Errorf("newPointerTransform(%+v) succeeded, want error", config)
+		}
+	}
+}
@@ -5,23 +5,59 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/device"
 	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
-	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/retry"
 )
 
 type Config struct {
-	MouseInput     string
-	KeyboardInput  string
-	MouseOutput    string
-	KeyboardOutput string
+	MouseInput      string // Mouse or touchpad event device, symlink or glob; discovered when empty
+	KeyboardInput   string // Keyboard event device, symlink or glob; discovered when empty
+	MouseOutput     string
+	KeyboardOutput  string
+	ConsumerOutput  string // Media keys are dropped when empty
+	DigitizerOutput string // Tablets and touchscreens are ignored when empty
+	GamepadOutput   string // Game controllers are ignored when empty
+	KeyboardNKRO    bool   // Keyboard gadget was set up with the NKRO descriptor
+	Mouse16Bit      bool   // Mouse gadget was set up with the 16-bit descriptor
+	MouseHiRes      bool   // Mouse gadget was set up with the high-resolution wheel descriptor
+	SystemControl   bool   // Relay power, sleep and wake keys to the host; device rules can override it
+	GrabInput       bool   // Keep relayed input devices from reaching the board's console
+	Pointer         PointerConfig
+	DeviceRules     device.Rules // Which input devices are relayed, and as what
 }
 
+// shutdownTimeout bounds how long the relay waits for its devices to be
+// released when it stops, since writes to a gadget the host doesn't read
+// can block
+const shutdownTimeout = 2 * time.Second
+
+// multipliersPath is where the Resolution Multiplier the host set on the
+// mouse gadget is kept across restarts of the relay
+var multipliersPath = "/run/bt-hid-relay/mouse-multipliers"
+
+// devicePollInterval is how often the relay looks for newly connected devices
+// when it isn't woken up by a hotplug event
+const devicePollInterval = 2 * time.Second
+
 type Relay struct {
-	config  Config
+	config           Config
+	mouseMultipliers *resolutionMultipliers // Resolution Multiplier the host set on the mouse gadget
+
+	// Mergers combining the devices relayed to each gadget
+	keyboardMerger *reportMerger
+	mouseMerger    *reportMerger
+	consumerMerger *reportMerger
+	systemMerger   *reportMerger
+
+	hotplug     *hotplug
+	supervisors sync.WaitGroup
+	states      chan DeviceStateChange
+
 	ctx     context.Context
 	cancel  context.CancelFunc
 	errChan chan error
@@ -31,25 +67,51 @@ type Relay struct {
 func NewRelay(config Config) *Relay {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Relay{
-		config:  config,
-		ctx:     ctx,
-		cancel:  cancel,
-		errChan: make(chan error, 2),
-		sigChan: make(chan os.Signal, 1),
+		config:           config,
+		mouseMultipliers: loadResolutionMultipliers(multipliersPath),
+		keyboardMerger:   newReportMerger(mergeKeyboardReports),
+		mouseMerger:      newReportMerger(mergeMouseReports),
+		consumerMerger:   newReportMerger(mergeConsumerReports),
+		systemMerger:     newReportMerger(mergeSystemReports),
+		hotplug:          &hotplug{},
+		states:           make(chan DeviceStateChange, 64),
+		ctx:              ctx,
+		cancel:           cancel,
+		errChan:          make(chan error, 2),
+		sigChan:          make(chan os.Signal, 1),
 	}
 }
 
 func (r *Relay) Start() error {
 	logger.Println("Bluetooth HID Relay starting...")
 
+	if _, err := newPointerTransform(r.config.Pointer); err != nil {
+		return fmt.Errorf("invalid pointer configuration: %v", err)
+	}
+	for _, pattern := range []string{r.config.MouseInput, r.config.KeyboardInput} {
+		if _, err := device.ResolveInputPaths(pattern); err != nil {
+			return err
+		}
+	}
+
 	// Setup signal handling
 	signal.Notify(r.sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	go r.handleSignals()
+	go r.hotplug.watch(r.ctx)
 
-	// Start device relaying
-	go r.handleMouseEvents()
-	go r.handleKeyboardEvents()
+	// Start device relaying. Every keyboard, mouse and touchpad is relayed,
+	// with touchpads sharing the mouse gadget, and tablets and game
+	// controllers when their gadgets exist.
+	deviceTypes := []string{"keyboard", "mouse", "touchpad"}
+	if optionalOutputAvailable(r.config.DigitizerOutput, "Digitizer output", "tablets and touchscreens") {
+		deviceTypes = append(deviceTypes, "digitizer")
+	}
+	if optionalOutputAvailable(r.config.GamepadOutput, "Gamepad output", "game controllers") {
+		deviceTypes = append(deviceTypes, "gamepad")
+	}
+	r.supervisors.Add(1)
+	go r.relayDevices(deviceTypes...)
 
 	// Wait for completion or error
 	return r.wait()
@@ -65,8 +127,10 @@ func (r *Relay) wait() error {
 	select {
 	case err := <-r.errChan:
 		r.Shutdown()
+		r.waitForSupervisors()
 		return fmt.Errorf("relay error: %v", err)
 	case <-r.ctx.Done():
+		r.waitForSupervisors()
 		if r.ctx.Err() == context.Canceled {
 			return nil
 		}
@@ -74,74 +138,346 @@ func (r *Relay) wait() error {
 	}
 }
 
-func (r *Relay) handleMouseEvents() {
-	timer := retry.NewBackoffTimer(5, time.Second)
+// waitForSupervisors waits for the device supervisors to release their
+// devices after a shutdown, for at most shutdownTimeout.
+func (r *Relay) waitForSupervisors() {
+	done := make(chan struct{})
+	go func() {
+		r.supervisors.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		logger.Printf("Devices not released after %v, stopping anyway", shutdownTimeout)
+	}
+}
+
+// States returns the channel reporting the state changes of the relayed
+// input devices, and that configured inputs are being searched for. Changes
+// are dropped while the channel is full.
+func (r *Relay) States() <-chan DeviceStateChange {
+	return r.states
+}
+
+// relayDevices relays every connected input device of the given types, each
+// under its own supervisor, until the relay shuts down. A device of several
+// types, like a keyboard with a pointing stick, is read by a single stream
+// feeding the routes of all its types, so grabbing it can't fail on a second
+// open. Devices connected later are picked up as soon as the kernel reports
+// them, or at the next poll. Configured inputs matching no device are
+// reported as searched for.
+func (r *Relay) relayDevices(deviceTypes ...string) {
+	defer r.supervisors.Done()
+
+	wake := r.hotplug.subscribe()
+	streaming := make(map[string]bool)
+	owners := make(map[string]string) // Exclusive type -> path of the device relayed as it
+	searching := make(map[string]bool)
+	ended := make(chan string)
+	ticker := time.NewTicker(devicePollInterval)
+	defer ticker.Stop()
 
 	for {
-		mouse, err := device.FindInputDevice("mouse")
-		delay := timer.NextDelay()
+		devices, missing, err := r.findDevices(deviceTypes)
 		if err != nil {
-			logger.Printf("%v, retrying in %.0f second(s)...", err, delay.Seconds())
-			time.Sleep(delay)
-			continue
+			logger.Printf("Failed to look for input devices: %v", err)
 		}
+		r.reportSearching(searching, missing)
 
-		logger.Printf("Mouse connected: %s", mouse)
+		for _, dev := range devices {
+			if streaming[dev.Path] {
+				continue
+			}
+			types := claimTypes(owners, dev.Path, dev.Types)
+			if len(types) == 0 {
+				continue
+			}
+			streaming[dev.Path] = true
+			description := strings.Join(types, "/")
+			logger.Printf("Found %s at: %s", description, dev.Path)
 
-		if err := streamDeviceEvents(r.ctx, mouse, r.config.MouseOutput, &MouseRelay{}); err != nil {
-			logger.Printf("Mouse relay error: %v, reconnecting...", err)
+			s := &supervisor{
+				description: description,
+				routes:      func() []route { return r.routes(dev.Path, types) },
+				grab:        r.config.GrabInput,
+				path:        dev.Path,
+				wake:        r.hotplug.subscribe(),
+				states:      r.states,
+			}
+			if identity, ok := lookupInputDevice(dev.Path); ok {
+				s.identity = &identity
+			}
+			r.supervisors.Add(1)
+			go func() {
+				defer r.supervisors.Done()
+				s.run(r.ctx)
+				r.hotplug.unsubscribe(s.wake)
+				select {
+				case ended <- dev.Path:
+				case <-r.ctx.Done():
+				}
+			}()
+		}
+
+		// Wait for the next lookup, forgetting devices whose supervisor
+		// stopped so they are picked up again if they come back. Another
+		// device may have taken over their event device already, so it is
+		// looked for right away.
+		for waiting := true; waiting; {
+			select {
+			case <-r.ctx.Done():
+				return
+			case path := <-ended:
+				delete(streaming, path)
+				releaseTypes(owners, path)
+				waiting = false
+			case <-wake:
+				waiting = false
+			case <-ticker.C:
+				waiting = false
+			}
 		}
 	}
 }
 
-func (r *Relay) handleKeyboardEvents() {
-	timer := retry.NewBackoffTimer(5, time.Second)
+// reportSearching reports the configured inputs that started or stopped
+// matching no device. searching holds the types reported as searched for.
+func (r *Relay) reportSearching(searching map[string]bool, missing []string) {
+	found := make(map[string]bool)
+	for deviceType := range searching {
+		found[deviceType] = true
+	}
+	for _, deviceType := range missing {
+		if !searching[deviceType] {
+			searching[deviceType] = true
+			reportState(r.states, DeviceStateChange{Device: deviceType, State: DeviceSearching})
+		}
+		delete(found, deviceType)
+	}
+	for deviceType := range found {
+		delete(searching, deviceType)
+	}
+}
 
-	for {
-		keyboard, err := device.FindInputDevice("keyboard")
-		delay := timer.NextDelay()
+// exclusiveTypes are relayed from one device at a time, since their gadgets
+// have no merger combining the reports of several devices.
+var exclusiveTypes = [...]string{"digitizer", "gamepad"}
+
+func isExclusiveType(deviceType string) bool {
+	for _, exclusive := range exclusiveTypes {
+		if deviceType == exclusive {
+			return true
+		}
+	}
+	return false
+}
+
+// claimTypes returns the types the device at path is relayed as, leaving out
+// exclusive types another device is relayed as already, and records the
+// device as the owner of the exclusive types it keeps.
+func claimTypes(owners map[string]string, path string, deviceTypes []string) []string {
+	var types []string
+	for _, deviceType := range deviceTypes {
+		if isExclusiveType(deviceType) {
+			if owner, ok := owners[deviceType]; ok && owner != path {
+				continue
+			}
+			owners[deviceType] = path
+		}
+		types = append(types, deviceType)
+	}
+	return types
+}
+
+// releaseTypes frees the exclusive types of a device that is no longer
+// relayed, so the next device of those types can take them.
+func releaseTypes(owners map[string]string, path string) {
+	for deviceType, owner := range owners {
+		if owner == path {
+			delete(owners, deviceType)
+		}
+	}
+}
+
+// findDevices returns the input devices to relay as the given types. Mice
+// and keyboards are the devices set in the configuration when it names them,
+// and are otherwise discovered like the other types. The mouse input stands
+// for every device on the mouse gadget, so it replaces touchpad discovery
+// too, and is relayed as a touchpad if it is one. Configured devices are
+// relayed even if the device rules would leave them out. The types whose
+// configured input matches no device are returned as missing.
+func (r *Relay) findDevices(deviceTypes []string) (devices []device.RelayedDevice, missing []string, err error) {
+	configured := map[string]string{"mouse": r.config.MouseInput, "touchpad": r.config.MouseInput, "keyboard": r.config.KeyboardInput}
+
+	var discovered []string
+	for _, deviceType := range deviceTypes {
+		if configured[deviceType] == "" {
+			discovered = append(discovered, deviceType)
+		}
+	}
+
+	if len(discovered) > 0 {
+		if devices, err = device.FindRelayedDevices(discovered, r.config.DeviceRules); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, deviceType := range deviceTypes {
+		pattern := configured[deviceType]
+		if pattern == "" || deviceType == "touchpad" {
+			continue // Touchpads are found through the mouse input
+		}
+
+		// A pattern can stop matching event devices while the relay runs;
+		// that only keeps this type from being relayed
+		paths, err := device.ResolveInputPaths(pattern)
 		if err != nil {
-			logger.Printf("%v, retrying in %.0f second(s)...", err, delay.Seconds())
-			time.Sleep(delay)
+			logger.Printf("No %s relayed: %v", deviceType, err)
+			missing = append(missing, deviceType)
 			continue
 		}
+		if len(paths) == 0 {
+			logger.DebugPrintf("No %s at %s", deviceType, pattern)
+			missing = append(missing, deviceType)
+		}
 
-		logger.Printf("Found keyboard at: %s", keyboard)
+		for _, path := range paths {
+			devices = addDeviceType(devices, path, configuredType(deviceType, path))
+		}
+	}
 
-		if err = streamDeviceEvents(r.ctx, keyboard, r.config.KeyboardOutput, &KeyboardRelay{}); err != nil {
-			logger.Printf("Keyboard relay error: %v, reconnecting...", err)
+	return devices, missing, nil
+}
+
+// configuredType returns the type a device set in the configuration as
+// deviceType is relayed as. Touchpads need their own converter to move the
+// pointer.
+func configuredType(deviceType, path string) string {
+	if deviceType == "mouse" {
+		if dev, ok := lookupInputDevice(path); ok && device.IsTouchpad(dev) {
+			return "touchpad"
 		}
 	}
+	return deviceType
 }
 
-// Shutdown gracefully stops the relay service
-func (r *Relay) Shutdown() {
-	logger.Println("Shutting down...")
-	r.sendReleaseEvents()
-	time.Sleep(100 * time.Millisecond)
-	r.cancel()
+// lookupInputDevice returns the input device whose event device is at path.
+var lookupInputDevice = func(path string) (device.InputDevice, bool) {
+	devices, err := device.FindInputDevices(func(dev device.InputDevice) bool { return dev.EventPath() == path })
+	if err != nil || len(devices) == 0 {
+		return device.InputDevice{}, false
+	}
+	return devices[0], true
+}
+
+// addDeviceType adds a type to the device at path, adding the device if it
+// isn't in the list yet.
+func addDeviceType(devices []device.RelayedDevice, path, deviceType string) []device.RelayedDevice {
+	for i := range devices {
+		if devices[i].Path == path {
+			devices[i].Types = append(devices[i].Types, deviceType)
+			return devices
+		}
+	}
+	return append(devices, device.RelayedDevice{Path: path, Types: []string{deviceType}})
 }
 
-func (r *Relay) sendReleaseEvents() {
-	logger.Println("Sending release events...")
+// routes returns the outputs fed by the input device at path, relayed as the
+// given types. Each device gets its own converters.
+func (r *Relay) routes(path string, deviceTypes []string) []route {
+	var routes []route
+	for _, deviceType := range deviceTypes {
+		switch deviceType {
+		case "keyboard":
+			routes = append(routes, r.keyboardRoutes(path)...)
+		case "mouse":
+			routes = append(routes, route{r.newMouseRelay(), r.config.MouseOutput, r.mouseMerger, false})
+		case "touchpad":
+			routes = append(routes, route{&TouchpadRelay{mouse: r.newMouseRelay()}, r.config.MouseOutput, r.mouseMerger, false})
+		case "digitizer":
+			routes = append(routes, route{&DigitizerRelay{}, r.config.DigitizerOutput, nil, false})
+		case "gamepad":
+			routes = append(routes, route{&GamepadRelay{}, r.config.GamepadOutput, nil, false})
+		}
+	}
+	return routes
+}
+
+// optionalOutputAvailable reports whether the gadget device of an optional
+// stream exists. Like media keys, these streams need gadget functions that
+// older setups lack, and are skipped without them.
+func optionalOutputAvailable(path, description, devices string) bool {
+	if path == "" {
+		return false
+	}
+	if _, err := os.Stat(path); err != nil {
+		logger.Printf("%s unavailable, %s disabled: %v", description, devices, err)
+		return false
+	}
+	return true
+}
+
+// newMouseRelay creates a mouse converter for the configured gadget layout
+// and pointer transform. The configuration was validated by Start. The
+// Resolution Multiplier is kept by the relay because the host only sets it
+// when it enumerates the gadget, not when the mouse reconnects.
+func (r *Relay) newMouseRelay() *MouseRelay {
+	pointer, _ := newPointerTransform(r.config.Pointer)
+	return &MouseRelay{
+		wide:        r.config.Mouse16Bit,
+		hiRes:       r.config.MouseHiRes,
+		pointer:     pointer,
+		multipliers: r.mouseMultipliers,
+	}
+}
+
+// keyboardRoutes returns the outputs fed by the keyboard at path. Media and
+// system control keys are only relayed when the consumer gadget exists, so a
+// gadget set up before it was added keeps working as a plain keyboard.
+func (r *Relay) keyboardRoutes(path string) []route {
+	routes := []route{{&KeyboardRelay{nkro: r.config.KeyboardNKRO}, r.config.KeyboardOutput, r.keyboardMerger, false}}
+	systemControl := r.systemControl(path)
 
-	// For keyboard: clear all modifiers and keys
-	keyboardRelease := []byte{0, 0, 0, 0, 0, 0, 0, 0}
-	if f, err := os.OpenFile(r.config.KeyboardOutput, os.O_WRONLY, 0666); err == nil {
-		for i := 0; i < 3; i++ { // Send multiple times to ensure it's received
-			f.Write(keyboardRelease)
-			time.Sleep(10 * time.Millisecond)
+	if r.config.ConsumerOutput == "" {
+		if systemControl {
+			logger.Printf("No consumer control output set, power keys of %s disabled", path)
 		}
-		f.Close()
+		return routes
 	}
 
-	// For mouse: clear all buttons and movement
-	mouseRelease := []byte{0, 0, 0, 0}
-	if f, err := os.OpenFile(r.config.MouseOutput, os.O_WRONLY, 0666); err == nil {
-		for i := 0; i < 3; i++ {
-			f.Write(mouseRelease)
-			time.Sleep(10 * time.Millisecond)
+	if _, err := os.Stat(r.config.ConsumerOutput); err != nil {
+		if systemControl {
+			logger.Printf("Consumer control output unavailable, media and power keys of %s disabled: %v", path, err)
+		} else {
+			logger.Printf("Consumer control output unavailable, media keys of %s disabled: %v", path, err)
 		}
-		f.Close()
+		return routes
 	}
+
+	routes = append(routes, route{&ConsumerRelay{}, r.config.ConsumerOutput, r.consumerMerger, true})
+	if systemControl {
+		routes = append(routes, route{&SystemRelay{}, r.config.ConsumerOutput, r.systemMerger, true})
+	}
+	return routes
+}
+
+// systemControl reports whether the power, sleep and wake keys of the input
+// device at path are relayed. The device rules can decide this per device,
+// including for devices set in the configuration.
+func (r *Relay) systemControl(path string) bool {
+	dev, ok := lookupInputDevice(path)
+	if !ok {
+		return r.config.SystemControl
+	}
+	return r.config.DeviceRules.SystemControl(dev, r.config.SystemControl)
+}
+
+// Shutdown gracefully stops the relay service. Each device stream releases
+// what its device holds on the host before it ends; wait gives them at most
+// shutdownTimeout, as writes to a gadget the host stopped polling block.
+func (r *Relay) Shutdown() {
+	logger.Println("Shutting down...")
+	r.cancel()
 }
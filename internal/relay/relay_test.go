@@ -0,0 +1,241 @@
+package relay
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/device"
+)
+
+func TestRelay_KeyboardRoutes(t *testing.T) {
+	consumerOutput := filepath.Join(t.TempDir(), "hidg2")
+	if err := os.WriteFile(consumerOutput, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		consumerOutput string
+		systemControl  bool
+		expected       []string
+	}{
+		{"no consumer gadget", "", true, []string{"keyboard"}},
+		{"missing consumer gadget", consumerOutput + ".missing", true, []string{"keyboard"}},
+		{"media keys only", consumerOutput, false, []string{"keyboard", "consumer"}},
+		{"media and system keys", consumerOutput, true, []string{"keyboard", "consumer", "system control"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRelay(Config{ConsumerOutput: tt.consumerOutput, SystemControl: tt.systemControl})
+
+			// No input device lives at this path, so the default applies
+			routes := r.keyboardRoutes("/dev/input/event-test")
+			var names []string
+			for _, rt := range routes {
+				names = append(names, rt.converter.name())
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("routes = %v, want %v", names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClaimTypes(t *testing.T) {
+	owners := make(map[string]string)
+
+	if got := claimTypes(owners, "/dev/input/event5", []string{"keyboard", "gamepad"}); !reflect.DeepEqual(got, []string{"keyboard", "gamepad"}) {
+		t.Errorf("first gamepad types = %v", got)
+	}
+	// The gamepad gadget is taken, so a second controller waits
+	if got := claimTypes(owners, "/dev/input/event6", []string{"gamepad"}); got != nil {
+		t.Errorf("second gamepad types = %v, want none", got)
+	}
+	if got := claimTypes(owners, "/dev/input/event7", []string{"mouse", "digitizer"}); !reflect.DeepEqual(got, []string{"mouse", "digitizer"}) {
+		t.Errorf("digitizer types = %v", got)
+	}
+
+	releaseTypes(owners, "/dev/input/event5")
+	if got := claimTypes(owners, "/dev/input/event6", []string{"gamepad"}); !reflect.DeepEqual(got, []string{"gamepad"}) {
+		t.Errorf("second gamepad types after the first went away = %v", got)
+	}
+}
+
+func TestRelay_Routes(t *testing.T) {
+	r := NewRelay(Config{DigitizerOutput: "/dev/hidg3", GamepadOutput: "/dev/hidg4"})
+
+	var names []string
+	for _, rt := range r.routes("/dev/input/event-test", []string{"mouse", "digitizer", "gamepad"}) {
+		names = append(names, rt.converter.name())
+	}
+	if expected := []string{"mouse", "digitizer", "gamepad"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("routes = %v, want %v", names, expected)
+	}
+}
+
+func TestRelay_Routes_ConsumerControl(t *testing.T) {
+	consumerOutput := filepath.Join(t.TempDir(), "hidg2")
+	if err := os.WriteFile(consumerOutput, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRelay(Config{ConsumerOutput: consumerOutput})
+
+	consumerControl := device.InputDevice{ // KEY_MUTE to KEY_VOLUMEUP (113-115)
+		Name:         "K380 Consumer Control",
+		Bus:          0x05,
+		Capabilities: map[string]device.Bitmap{"EV": {0x3}, "KEY": {0, 1<<(113-64) | 1<<(114-64) | 1<<(115-64)}},
+	}
+	types := r.config.DeviceRules.Types(consumerControl, []string{"keyboard", "mouse", "touchpad"})
+
+	var names []string
+	for _, rt := range r.routes("/dev/input/event-test", types) {
+		names = append(names, rt.converter.name())
+	}
+	if expected := []string{"keyboard", "consumer"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("routes = %v, want %v", names, expected)
+	}
+}
+
+func TestRelay_FindDevices_Configured(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"event3", "event5", "event6"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keyboard, mouse, touchpad := filepath.Join(dir, "event3"), filepath.Join(dir, "event5"), filepath.Join(dir, "event6")
+
+	touchpadDevice := device.InputDevice{Capabilities: map[string]device.Bitmap{
+		"EV":  {1 << 3},                         // EV_ABS
+		"ABS": {1<<53 | 1<<54},                  // ABS_MT_POSITION_X, ABS_MT_POSITION_Y
+		"KEY": {0, 0, 0, 0, 0, 1 << (325 % 64)}, // BTN_TOOL_FINGER
+	}}
+	originalLookup := lookupInputDevice
+	lookupInputDevice = func(path string) (device.InputDevice, bool) {
+		return touchpadDevice, path == touchpad
+	}
+	defer func() {
+		lookupInputDevice = originalLookup
+	}()
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected []device.RelayedDevice
+		missing  []string
+	}{
+		{
+			name:   "Keyboard and mouse",
+			config: Config{MouseInput: mouse, KeyboardInput: keyboard},
+			expected: []device.RelayedDevice{
+				{Path: mouse, Types: []string{"mouse"}},
+				{Path: keyboard, Types: []string{"keyboard"}},
+			},
+		},
+		{
+			name:   "Combined keyboard and mouse",
+			config: Config{MouseInput: keyboard, KeyboardInput: keyboard},
+			expected: []device.RelayedDevice{
+				{Path: keyboard, Types: []string{"mouse", "keyboard"}},
+			},
+		},
+		{
+			name:   "Touchpad as the mouse input",
+			config: Config{MouseInput: filepath.Join(dir, "event[56]"), KeyboardInput: keyboard},
+			expected: []device.RelayedDevice{
+				{Path: mouse, Types: []string{"mouse"}},
+				{Path: touchpad, Types: []string{"touchpad"}},
+				{Path: keyboard, Types: []string{"keyboard"}},
+			},
+		},
+		{
+			name:   "Keyboard not connected",
+			config: Config{MouseInput: mouse, KeyboardInput: filepath.Join(dir, "event9")},
+			expected: []device.RelayedDevice{
+				{Path: mouse, Types: []string{"mouse"}},
+			},
+			missing: []string{"keyboard"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every type is configured, so nothing is discovered
+			r := NewRelay(tt.config)
+			devices, missing, err := r.findDevices([]string{"mouse", "touchpad", "keyboard"})
+			if err != nil {
+				t.Fatalf("findDevices() error = %v", err)
+			}
+			if !reflect.DeepEqual(devices, tt.expected) {
+				t.Errorf("findDevices() = %+v, want %+v", devices, tt.expected)
+			}
+			if !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("findDevices() missing = %v, want %v", missing, tt.missing)
+			}
+		})
+	}
+}
+
+func TestRelay_ReportSearching(t *testing.T) {
+	r := NewRelay(Config{})
+	searching := make(map[string]bool)
+
+	r.reportSearching(searching, []string{"keyboard"})
+	r.reportSearching(searching, []string{"keyboard"}) // Still searching, not reported again
+	r.reportSearching(searching, nil)
+	r.reportSearching(searching, []string{"keyboard"}) // Gone again
+
+	for i := 0; i < 2; i++ {
+		if change := <-r.States(); change.Device != "keyboard" || change.State != DeviceSearching {
+			t.Errorf("state change = %+v, want the keyboard searching", change)
+		}
+	}
+	select {
+	case change := <-r.States():
+		t.Errorf("unexpected state change %+v", change)
+	default:
+	}
+}
+
+func TestAddDeviceType(t *testing.T) {
+	var devices []device.RelayedDevice
+	devices = addDeviceType(devices, "/dev/input/event3", "keyboard")
+	devices = addDeviceType(devices, "/dev/input/event5", "mouse")
+	devices = addDeviceType(devices, "/dev/input/event3", "mouse")
+
+	expected := []device.RelayedDevice{
+		{Path: "/dev/input/event3", Types: []string{"keyboard", "mouse"}},
+		{Path: "/dev/input/event5", Types: []string{"mouse"}},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("addDeviceType() = %+v, want %+v", devices, expected)
+	}
+}
+
+func TestRelay_ShutdownDoesNotWaitForGadgets(t *testing.T) {
+	// Opening a FIFO without a reader blocks, like a gadget the host stopped polling
+	output := filepath.Join(t.TempDir(), "hidg1")
+	if err := syscall.Mkfifo(output, 0600); err != nil {
+		t.Skipf("Mkfifo() error = %v", err)
+	}
+	r := NewRelay(Config{KeyboardOutput: output, MouseOutput: output})
+
+	done := make(chan struct{})
+	go func() {
+		r.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown() blocked on a gadget")
+	}
+	if r.ctx.Err() == nil {
+		t.Error("Shutdown() did not cancel the relay")
+	}
+}
@@ -0,0 +1,162 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/device"
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/retry"
+)
+
+// DeviceState is a stage in the life of a relayed input device.
+type DeviceState int
+
+const (
+	DeviceSearching  DeviceState = iota // Looking for a configured input device
+	DeviceConnected                     // Found the device, opening it
+	DeviceStreaming                     // Relaying the device's events
+	DeviceBackingOff                    // Waiting to reconnect after an error
+	DeviceStopped                       // The device went away or the relay shut down
+)
+
+var deviceStateNames = [...]string{"searching", "connected", "streaming", "backing off", "stopped"}
+
+func (s DeviceState) String() string {
+	if s < 0 || int(s) >= len(deviceStateNames) {
+		return fmt.Sprintf("DeviceState(%d)", int(s))
+	}
+	return deviceStateNames[s]
+}
+
+// DeviceStateChange reports that a relayed input device entered a new state.
+type DeviceStateChange struct {
+	Device string // Types the device is relayed as, e.g. "keyboard/mouse"
+	Path   string // Event device, empty while searching
+	State  DeviceState
+	Err    error // Error the supervisor is backing off from
+}
+
+// supervisor runs the lifecycle of one relayed input device: streaming its
+// events and backing off after errors, until the device goes away or the
+// relay shuts down. Finding devices is left to the relay. The device files
+// are closed whenever a stream ends, so nothing is held while backing off.
+type supervisor struct {
+	description string // Types the device is relayed as
+	routes      func() []route
+	grab        bool
+
+	// The device is only reopened while it is still the one found at path
+	// when the supervisor started, since the kernel hands a freed event
+	// number to the next device. Without an identity only the path is
+	// checked.
+	path     string
+	identity *device.InputDevice
+
+	wake   <-chan struct{}          // Input devices were added, cuts waits short
+	states chan<- DeviceStateChange // Receives state changes, dropped when full
+
+	state     DeviceState
+	statePath string
+	reported  bool // A state was reported already
+}
+
+func (s *supervisor) run(ctx context.Context) {
+	backoff := retry.NewBackoffTimer(5, time.Second)
+	retried := false // The last reconnect skipped the backoff
+	defer func() { s.setState(DeviceStopped, s.statePath, nil) }()
+
+	for ctx.Err() == nil {
+		path := s.path
+		if !s.present() {
+			return // The relay looks for it again
+		}
+
+		streamed := false
+		s.setState(DeviceConnected, path, nil)
+		err := streamDeviceEvents(ctx, path, s.grab, func() {
+			s.setState(DeviceStreaming, path, nil)
+			backoff.Reset()
+			streamed, retried = true, false
+		}, s.routes()...)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		// A stream that was running mostly ends because the device dropped
+		// its connection, and it may be back already, so only a device
+		// failing again right away is backed off from
+		if streamed && !retried {
+			logger.Printf("Relay error for %s %s: %v, reconnecting...", s.description, path, err)
+			retried = true
+			continue
+		}
+		retried = false
+
+		delay := backoff.NextDelay()
+		logger.Printf("Relay error for %s %s: %v, reconnecting in %.0f second(s)...", s.description, path, err, delay.Seconds())
+		s.setState(DeviceBackingOff, path, err)
+		s.wait(ctx, delay)
+	}
+}
+
+// present reports whether the device is still at its path. A different
+// device that took over the event number counts as gone, so the relay finds
+// it again with its own types, rules and settings.
+func (s *supervisor) present() bool {
+	if _, err := os.Stat(s.path); err != nil {
+		logger.Printf("%s %s went away", s.description, s.path)
+		return false
+	}
+	if s.identity == nil {
+		return true
+	}
+	if dev, ok := lookupInputDevice(s.path); !ok || !sameInputDevice(dev, *s.identity) {
+		logger.Printf("%s %s went away, the event device now belongs to another device", s.description, s.path)
+		return false
+	}
+	return true
+}
+
+// sameInputDevice reports whether a and b are the same connection of the
+// same input device. The sysfs path changes when a device reconnects.
+func sameInputDevice(a, b device.InputDevice) bool {
+	return a.Name == b.Name && a.Uniq == b.Uniq && a.Phys == b.Phys && a.Sysfs == b.Sysfs &&
+		a.Bus == b.Bus && a.Vendor == b.Vendor && a.Product == b.Product
+}
+
+// wait waits until the delay has passed, an input device was added or ctx is
+// done, whichever comes first.
+func (s *supervisor) wait(ctx context.Context, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-s.wake:
+	case <-ctx.Done():
+	}
+}
+
+// setState records a state change and reports it, unless nothing changed.
+func (s *supervisor) setState(state DeviceState, path string, err error) {
+	if s.reported && state == s.state && path == s.statePath && err == nil {
+		return
+	}
+	s.state, s.statePath, s.reported = state, path, true
+	reportState(s.states, DeviceStateChange{Device: s.description, Path: path, State: state, Err: err})
+}
+
+// reportState sends a state change to states, dropping it when nobody keeps
+// up with the changes.
+func reportState(states chan<- DeviceStateChange, change DeviceStateChange) {
+	if states == nil {
+		return
+	}
+	select {
+	case states <- change:
+	default:
+	}
+}
@@ -0,0 +1,218 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/device"
+)
+
+// nextState returns the next state change a supervisor reports.
+func nextState(t *testing.T, states <-chan DeviceStateChange) DeviceStateChange {
+	t.Helper()
+	select {
+	case change := <-states:
+		return change
+	case <-time.After(2 * time.Second):
+		t.Fatal("no state change reported")
+		return DeviceStateChange{}
+	}
+}
+
+func expectState(t *testing.T, states <-chan DeviceStateChange, want DeviceState) DeviceStateChange {
+	t.Helper()
+	change := nextState(t, states)
+	if change.State != want {
+		t.Fatalf("state = %v, want %v", change.State, want)
+	}
+	return change
+}
+
+func TestSupervisor_Streaming(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "event3")
+	outputPath := filepath.Join(dir, "hidg1")
+	if err := syscall.Mkfifo(inputPath, 0600); err != nil {
+		t.Skipf("Mkfifo() error = %v", err)
+	}
+	if err := os.WriteFile(outputPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	states := make(chan DeviceStateChange, 16)
+	s := &supervisor{
+		description: "keyboard",
+		routes:      func() []route { return []route{{&KeyboardRelay{}, outputPath, nil, false}} },
+		path:        inputPath,
+		states:      states,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+
+	expectState(t, states, DeviceConnected)
+	if change := expectState(t, states, DeviceStreaming); change.Path != inputPath {
+		t.Errorf("streaming path = %q, want %q", change.Path, inputPath)
+	}
+
+	// Press A
+	input, err := os.OpenFile(inputPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	if err := binary.Write(input, binary.LittleEndian, &InputEvent{Type: 1, Code: 30, Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0, 0, 0x04, 0, 0, 0, 0, 0}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		written, _ := os.ReadFile(outputPath)
+		if bytes.HasPrefix(written, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("written reports = %v, want %v first", written, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The stream waits for the next event; shutting down must not
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("supervisor did not stop after shutdown")
+	}
+	expectState(t, states, DeviceStopped)
+
+	written, _ := os.ReadFile(outputPath)
+	if release := make([]byte, bootReportLength); !bytes.HasSuffix(written, release) {
+		t.Errorf("written reports = %v, want a release report last", written)
+	}
+}
+
+func TestSupervisor_ReconnectsRightAfterStreaming(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "event3")
+	outputPath := filepath.Join(dir, "hidg1")
+	if err := syscall.Mkfifo(inputPath, 0600); err != nil {
+		t.Skipf("Mkfifo() error = %v", err)
+	}
+	if err := os.WriteFile(outputPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without feedback to write back, the input device is opened read-only
+	// and sees the end of the input
+	states := make(chan DeviceStateChange, 16)
+	s := &supervisor{
+		description: "keyboard",
+		routes:      func() []route { return []route{{&ConsumerRelay{}, outputPath, nil, false}} },
+		path:        inputPath,
+		states:      states,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+
+	expectState(t, states, DeviceConnected)
+	input, err := os.OpenFile(inputPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, states, DeviceStreaming)
+
+	// Closing the writer ends the stream with a read error, like a
+	// disconnect; the device is opened again without backing off first
+	input.Close()
+	select {
+	case change := <-states:
+		if change.State != DeviceConnected {
+			t.Fatalf("state after the stream failed = %v, want %v", change.State, DeviceConnected)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("supervisor did not reconnect right after the stream failed")
+	}
+
+	// Let the pending open complete so the supervisor can stop
+	input, err = os.OpenFile(inputPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	expectState(t, states, DeviceStreaming)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("supervisor did not stop after shutdown")
+	}
+}
+
+func TestSupervisor_StopsWhenDeviceGone(t *testing.T) {
+	states := make(chan DeviceStateChange, 16)
+	s := &supervisor{
+		description: "mouse",
+		routes:      func() []route { return nil },
+		path:        filepath.Join(t.TempDir(), "event9"),
+		states:      states,
+	}
+
+	s.run(context.Background())
+	expectState(t, states, DeviceStopped)
+}
+
+func TestSupervisor_StopsWhenDeviceReplaced(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "event9")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another device took over the event number after the first one left
+	keyboard := device.InputDevice{Name: "Keyboard K380", Bus: 0x05, Sysfs: "/devices/virtual/misc/uhid/0005:046D:B35F.0001/input/input12"}
+	originalLookup := lookupInputDevice
+	lookupInputDevice = func(string) (device.InputDevice, bool) {
+		return device.InputDevice{Name: "Power Button", Bus: 0x19, Sysfs: "/devices/LNXSYSTM:00/LNXPWRBN:00/input/input13"}, true
+	}
+	defer func() {
+		lookupInputDevice = originalLookup
+	}()
+
+	states := make(chan DeviceStateChange, 16)
+	s := &supervisor{
+		description: "keyboard",
+		routes:      func() []route { return nil },
+		path:        path,
+		identity:    &keyboard,
+		states:      states,
+	}
+
+	s.run(context.Background())
+	expectState(t, states, DeviceStopped) // Never connected to the other device
+}
+
+func TestDeviceState_String(t *testing.T) {
+	if got := DeviceBackingOff.String(); got != "backing off" {
+		t.Errorf("DeviceBackingOff.String() = %q", got)
+	}
+	if got := DeviceState(9).String(); got != "DeviceState(9)" {
+		t.Errorf("DeviceState(9).String() = %q", got)
+	}
+}
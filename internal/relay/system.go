@@ -0,0 +1,53 @@
+package relay
+
+// SystemRelay converts power management keys into Generic Desktop System
+// Control reports. It shares the consumer gadget and is told apart from
+// Consumer Control reports by its report ID.
+type SystemRelay struct {
+	held byte // Bitmap of held system control usages
+}
+
+const systemReportID = 0x02
+
+// Linux event code to System Control report bit mapping. The bits follow
+// the usage range System Power Down (0x81) to System Wake Up (0x83) declared
+// in the gadget descriptor.
+var systemKeyMap = map[uint16]byte{
+	116: 0x01, // KEY_POWER -> System Power Down
+	142: 0x02, // KEY_SLEEP -> System Sleep
+	143: 0x04, // KEY_WAKEUP -> System Wake Up
+}
+
+func (s *SystemRelay) convertEvent(event InputEvent) ([]byte, error) {
+	mask, exists := systemKeyMap[event.Code]
+	if !exists {
+		return nil, nil
+	}
+
+	switch event.Value {
+	case 0: // Release
+		s.held &^= mask
+	case 1: // Press
+		s.held |= mask
+	default: // Auto-repeat is left to the host
+		return nil, nil
+	}
+
+	return []byte{systemReportID, s.held}, nil
+}
+
+func (s *SystemRelay) validateEvent(event InputEvent) bool {
+	if event.Type != 1 { // EV_KEY
+		return false
+	}
+	_, exists := systemKeyMap[event.Code]
+	return exists
+}
+
+func (s *SystemRelay) releaseReport() []byte {
+	return []byte{systemReportID, 0}
+}
+
+func (s *SystemRelay) name() string {
+	return "system control"
+}
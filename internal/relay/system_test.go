@@ -0,0 +1,32 @@
+package relay
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSystemRelay_ConvertEvent(t *testing.T) {
+	steps := []struct {
+		name       string
+		event      InputEvent
+		wantReport []byte
+	}{
+		{"sleep press", InputEvent{Type: 1, Code: 142, Value: 1}, []byte{0x02, 0x02}},
+		{"sleep repeat", InputEvent{Type: 1, Code: 142, Value: 2}, nil},
+		{"power press while sleep held", InputEvent{Type: 1, Code: 116, Value: 1}, []byte{0x02, 0x03}},
+		{"sleep release", InputEvent{Type: 1, Code: 142, Value: 0}, []byte{0x02, 0x01}},
+		{"power release", InputEvent{Type: 1, Code: 116, Value: 0}, []byte{0x02, 0x00}},
+		{"wake up press", InputEvent{Type: 1, Code: 143, Value: 1}, []byte{0x02, 0x04}},
+	}
+
+	s := &SystemRelay{}
+	for _, step := range steps {
+		got, err := s.convertEvent(step.event)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if !bytes.Equal(got, step.wantReport) {
+			t.Fatalf("%s: report = %v, want %v", step.name, got, step.wantReport)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package relay
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/bahaaador/bluetooth-usb-peripheral-relay/internal/logger"
+)
+
+// TouchpadRelay turns multitouch frames from a touchpad into mouse reports
+// for the mouse gadget: one finger moves the pointer, two fingers scroll,
+// and tapping with one, two or three fingers clicks the left, right or
+// middle button. The touchpad's own buttons are passed through. Reports are
+// built by a MouseRelay, so they follow the mouse gadget's layout and the
+// pointer settings.
+type TouchpadRelay struct {
+	mouse *MouseRelay
+
+	unitsPerMMX, unitsPerMMY float64 // Axis resolution reported by the device
+
+	slot     int32 // Slot the following ABS_MT_* events refer to
+	contacts [touchpadMaxSlots]touchContact
+
+	// Gesture state of the current touch, from the first finger down to the
+	// last finger up
+	fingers    int // Fingers down in the previous frame
+	maxFingers int
+	touchStart time.Duration
+	travel     float64 // Millimeters moved since the touch started
+	clicked    bool    // A physical button was used during the touch
+
+	remX, remY           float64 // Pointer motion not reported yet, in counts
+	scrollRemX           float64 // Scrolling not reported yet, in hi-res units
+	scrollRemY           float64
+	wheelHiRes, panHiRes int32 // Hi-res units towards the next wheel detent
+
+	pending [][]byte // Reports waiting for nextReport
+}
+
+// touchContact is the state of one multitouch slot.
+type touchContact struct {
+	active       bool // The slot has a finger on the touchpad
+	tracked      bool // The finger was already down in the previous frame
+	x, y         int32
+	lastX, lastY int32 // Position in the previous frame
+}
+
+// Highest number of fingers tracked at once
+const touchpadMaxSlots = 10
+
+// Touchpads that don't report their resolution are assumed to be this wide
+const touchpadDefaultWidthMM = 100
+
+// Gesture tuning. Motion is scaled to roughly a 500 DPI mouse before the
+// pointer transform is applied; -pointer-sensitivity adjusts it further.
+const (
+	touchpadCountsPerMM = 20.0
+	scrollMMPerDetent   = 8.0
+	tapMaxDuration      = 180 * time.Millisecond
+	tapMaxTravelMM      = 2.0
+)
+
+// Linux multitouch event codes
+const (
+	absMTSlot       = 47 // ABS_MT_SLOT
+	absMTPositionX  = 53 // ABS_MT_POSITION_X
+	absMTPositionY  = 54 // ABS_MT_POSITION_Y
+	absMTTrackingID = 57 // ABS_MT_TRACKING_ID
+)
+
+// Mouse buttons clicked by tapping with one, two or three fingers
+var tapButtons = [...]uint16{
+	272, // BTN_LEFT
+	273, // BTN_RIGHT
+	274, // BTN_MIDDLE
+}
+
+// probeDevice reads the touchpad's resolution and current slot, and forgets
+// the contacts of a previous connection.
+func (t *TouchpadRelay) probeDevice(inputFile *os.File) error {
+	var err error
+	if t.unitsPerMMX, err = touchpadResolution(inputFile, absMTPositionX); err != nil {
+		return err
+	}
+	if t.unitsPerMMY, err = touchpadResolution(inputFile, absMTPositionY); err != nil {
+		return err
+	}
+	logger.DebugPrintf("Touchpad resolution: %.1f x %.1f units/mm", t.unitsPerMMX, t.unitsPerMMY)
+
+	t.resetTouch()
+	t.resyncSlot(inputFile)
+	return t.mouse.probeDevice(inputFile)
+}
+
+// touchpadResolution returns the units per millimeter of an axis, estimated
+// from its range if the device doesn't report it.
+func touchpadResolution(inputFile *os.File, axis uint16) (float64, error) {
+	info, err := readAbsInfo(inputFile, axis)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read range of axis %d: %v", axis, err)
+	}
+	if info.Resolution > 0 {
+		return float64(info.Resolution), nil
+	}
+	if info.Maximum <= info.Minimum {
+		return 0, fmt.Errorf("device reports no usable range for axis %d", axis)
+	}
+	return float64(info.Maximum-info.Minimum) / touchpadDefaultWidthMM, nil
+}
+
+func (t *TouchpadRelay) convertEvent(event InputEvent) ([]byte, error) {
+	logger.DebugPrintf("Touchpad event: type=%d, code=%d, value=%d", event.Type, event.Code, event.Value)
+
+	switch event.Type {
+	case 0: // EV_SYN
+		switch event.Code {
+		case 0: // SYN_REPORT
+			t.processFrame(eventTime(event))
+			return t.nextReport(), nil
+		case 3: // SYN_DROPPED
+			// The contacts can't be trusted anymore; start over with the next touch
+			t.resetTouch()
+			t.resyncSlot(t.mouse.input)
+			t.mouse.convertEvent(event)
+		}
+	case 1: // EV_KEY
+		if event.Value == 1 {
+			t.clicked = true // Pressing the touchpad down isn't a tap
+		}
+		t.mouse.convertEvent(event)
+	case 3: // EV_ABS
+		if event.Code == absMTSlot {
+			t.slot = event.Value
+			return nil, nil
+		}
+		if t.slot < 0 || t.slot >= touchpadMaxSlots {
+			return nil, nil
+		}
+		contact := &t.contacts[t.slot]
+		switch event.Code {
+		case absMTTrackingID:
+			contact.active = event.Value >= 0
+		case absMTPositionX:
+			contact.x = event.Value
+		case absMTPositionY:
+			contact.y = event.Value
+		}
+	}
+
+	return nil, nil
+}
+
+// processFrame interprets a complete multitouch frame and queues the mouse
+// reports it results in.
+func (t *TouchpadRelay) processFrame(timestamp time.Duration) {
+	fingers, dx, dy := t.updateContacts()
+
+	if fingers > 0 && t.fingers == 0 {
+		t.touchStart = timestamp
+		t.maxFingers, t.travel = 0, 0
+	}
+	t.maxFingers = max(t.maxFingers, fingers)
+
+	mmX, mmY := dx/t.unitsPerMMX, dy/t.unitsPerMMY
+	t.travel += math.Hypot(mmX, mmY)
+
+	switch fingers {
+	case 1:
+		t.movePointer(mmX, mmY)
+	case 2:
+		t.scroll(mmX, mmY)
+	}
+	t.queueMouseReports(timestamp)
+
+	if fingers == 0 && t.fingers > 0 && t.isTap(timestamp) {
+		button := tapButtons[t.maxFingers-1]
+		logger.DebugPrintf("Touchpad tap with %d finger(s)", t.maxFingers)
+		t.mouse.convertEvent(InputEvent{Type: 1, Code: button, Value: 1})
+		t.queueMouseReports(timestamp)
+		t.mouse.convertEvent(InputEvent{Type: 1, Code: button, Value: 0})
+		t.queueMouseReports(timestamp)
+	}
+	if fingers == 0 {
+		t.clicked = false
+	}
+
+	t.fingers = fingers
+}
+
+// updateContacts counts the fingers on the touchpad and returns the average
+// motion of the fingers that were already down in the previous frame, so
+// fingers landing or lifting don't make the pointer jump.
+func (t *TouchpadRelay) updateContacts() (fingers int, dx, dy float64) {
+	var moving int
+	for i := range t.contacts {
+		contact := &t.contacts[i]
+		if contact.active {
+			fingers++
+			if contact.tracked {
+				dx += float64(contact.x - contact.lastX)
+				dy += float64(contact.y - contact.lastY)
+				moving++
+			}
+		}
+		contact.tracked = contact.active
+		contact.lastX, contact.lastY = contact.x, contact.y
+	}
+
+	if moving > 0 {
+		dx, dy = dx/float64(moving), dy/float64(moving)
+	}
+	return fingers, dx, dy
+}
+
+func (t *TouchpadRelay) isTap(timestamp time.Duration) bool {
+	return !t.clicked &&
+		t.maxFingers <= len(tapButtons) &&
+		timestamp-t.touchStart <= tapMaxDuration &&
+		t.travel <= tapMaxTravelMM
+}
+
+// movePointer sends one-finger motion as relative pointer motion.
+func (t *TouchpadRelay) movePointer(mmX, mmY float64) {
+	x := mmX*touchpadCountsPerMM + t.remX
+	y := mmY*touchpadCountsPerMM + t.remY
+	outX, outY := math.Trunc(x), math.Trunc(y)
+	t.remX, t.remY = x-outX, y-outY
+
+	if outX != 0 {
+		t.mouse.convertEvent(InputEvent{Type: 2, Code: 0, Value: int32(outX)}) // REL_X
+	}
+	if outY != 0 {
+		t.mouse.convertEvent(InputEvent{Type: 2, Code: 1, Value: int32(outY)}) // REL_Y
+	}
+}
+
+// scroll sends two-finger motion as wheel and AC Pan movement. The content
+// follows the fingers, as with natural scrolling on Windows and macOS
+// touchpads.
+func (t *TouchpadRelay) scroll(mmX, mmY float64) {
+	wheel := mmY*hiResPerDetent/scrollMMPerDetent + t.scrollRemY
+	pan := -mmX*hiResPerDetent/scrollMMPerDetent + t.scrollRemX
+	outWheel, outPan := math.Trunc(wheel), math.Trunc(pan)
+	t.scrollRemY, t.scrollRemX = wheel-outWheel, pan-outPan
+
+	t.sendScroll(int32(outWheel), &t.wheelHiRes, 11, 8) // REL_WHEEL_HI_RES, REL_WHEEL
+	t.sendScroll(int32(outPan), &t.panHiRes, 12, 6)     // REL_HWHEEL_HI_RES, REL_HWHEEL
+}
+
+// sendScroll sends scrolling in hi-res units, along with a detent each time
+// the hi-res units add up to one, like a mouse with a high-resolution wheel.
+func (t *TouchpadRelay) sendScroll(hiRes int32, accumulated *int32, hiResCode, detentCode uint16) {
+	if hiRes == 0 {
+		return
+	}
+	t.mouse.convertEvent(InputEvent{Type: 2, Code: hiResCode, Value: hiRes})
+
+	*accumulated += hiRes
+	if detents := *accumulated / hiResPerDetent; detents != 0 {
+		*accumulated -= detents * hiResPerDetent
+		t.mouse.convertEvent(InputEvent{Type: 2, Code: detentCode, Value: detents})
+	}
+}
+
+// queueMouseReports closes the mouse relay's frame and queues the reports
+// it produces.
+func (t *TouchpadRelay) queueMouseReports(timestamp time.Duration) {
+	syn := InputEvent{Type: 0, Code: 0} // SYN_REPORT
+	syn.Time.Sec = uint64(timestamp / time.Second)
+	syn.Time.Usec = uint64(timestamp % time.Second / time.Microsecond)
+
+	report, _ := t.mouse.convertEvent(syn)
+	for ; report != nil; report = t.mouse.nextReport() {
+		t.pending = append(t.pending, report)
+	}
+}
+
+// resetTouch forgets all contacts and the gesture in progress.
+func (t *TouchpadRelay) resetTouch() {
+	t.slot = 0
+	t.contacts = [touchpadMaxSlots]touchContact{}
+	t.fingers, t.maxFingers, t.travel, t.clicked = 0, 0, 0, false
+	t.wheelHiRes, t.panHiRes = 0, 0
+}
+
+// resyncSlot reads back the slot the following ABS_MT_* events refer to. The
+// kernel only sends ABS_MT_SLOT when the slot changes, so it isn't
+// necessarily 0 when the touchpad is opened or events were dropped.
+func (t *TouchpadRelay) resyncSlot(inputFile *os.File) {
+	if inputFile == nil {
+		return
+	}
+	info, err := readAbsInfo(inputFile, absMTSlot)
+	if err != nil {
+		logger.DebugPrintf("Failed to read touchpad slot: %v", err)
+		return
+	}
+	t.slot = info.Value
+}
+
+// nextReport returns the next queued mouse report.
+func (t *TouchpadRelay) nextReport() []byte {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	report := t.pending[0]
+	t.pending = t.pending[1:]
+	return report
+}
+
+// feedbackEnabled follows the mouse relay, which owns the wheel layout.
+func (t *TouchpadRelay) feedbackEnabled() bool {
+	return t.mouse.feedbackEnabled()
+}
+
+// feedbackEvents passes the Resolution Multiplier on to the mouse relay.
+func (t *TouchpadRelay) feedbackEvents(report []byte) []InputEvent {
+	return t.mouse.feedbackEvents(report)
+}
+
+func (t *TouchpadRelay) validateEvent(event InputEvent) bool {
+	switch event.Type {
+	case 0: // EV_SYN
+		return true // Closes the frame
+	case 1: // EV_KEY
+		return event.Code >= 272 && event.Code <= 274 // BTN_LEFT, BTN_RIGHT, BTN_MIDDLE
+	case 3: // EV_ABS
+		switch event.Code {
+		case absMTSlot, absMTPositionX, absMTPositionY, absMTTrackingID:
+			return true
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func (t *TouchpadRelay) releaseReport() []byte {
+	return t.mouse.releaseReport()
+}
+
+func (t *TouchpadRelay) name() string {
+	return "touchpad"
+}
@@ -0,0 +1,154 @@
+package relay
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// touchFrame returns the events of a multitouch frame at the given time,
+// with one finger per position and the fingers in the first slots.
+func touchFrame(at time.Duration, previous int, positions ...[2]int32) []InputEvent {
+	var events []InputEvent
+	for slot := 0; slot < max(previous, len(positions)); slot++ {
+		events = append(events, InputEvent{Type: 3, Code: absMTSlot, Value: int32(slot)})
+		if slot >= len(positions) {
+			events = append(events, InputEvent{Type: 3, Code: absMTTrackingID, Value: -1})
+			continue
+		}
+		events = append(events,
+			InputEvent{Type: 3, Code: absMTTrackingID, Value: int32(slot)},
+			InputEvent{Type: 3, Code: absMTPositionX, Value: positions[slot][0]},
+			InputEvent{Type: 3, Code: absMTPositionY, Value: positions[slot][1]},
+		)
+	}
+
+	syn := InputEvent{Type: 0, Code: 0}
+	syn.Time.Sec = uint64(at / time.Second)
+	syn.Time.Usec = uint64(at % time.Second / time.Microsecond)
+	return append(events, syn)
+}
+
+// collectReports feeds events to a touchpad relay and returns all reports,
+// including the ones queued for nextReport.
+func collectReports(t *testing.T, touchpad *TouchpadRelay, events []InputEvent) [][]byte {
+	t.Helper()
+
+	var reports [][]byte
+	for _, event := range events {
+		report, err := touchpad.convertEvent(event)
+		if err != nil {
+			t.Fatalf("convertEvent(%+v) error = %v", event, err)
+		}
+		for ; report != nil; report = touchpad.nextReport() {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+func newTestTouchpad() *TouchpadRelay {
+	// 10 units per millimeter
+	return &TouchpadRelay{mouse: &MouseRelay{}, unitsPerMMX: 10, unitsPerMMY: 10}
+}
+
+func TestTouchpadRelay_Pointer(t *testing.T) {
+	touchpad := newTestTouchpad()
+
+	var events []InputEvent
+	events = append(events, touchFrame(0, 0, [2]int32{100, 100})...)
+	events = append(events, touchFrame(300*time.Millisecond, 1, [2]int32{110, 95})...) // 1mm right, 0.5mm up
+	events = append(events, touchFrame(310*time.Millisecond, 1)...)
+
+	want := [][]byte{{0, 20, 0xf6, 0, 0}} // 20 counts right, 10 up
+	if got := collectReports(t, touchpad, events); !equalReports(got, want) {
+		t.Errorf("reports = %v, want %v", got, want)
+	}
+}
+
+func TestTouchpadRelay_TwoFingerScroll(t *testing.T) {
+	touchpad := newTestTouchpad()
+
+	var events []InputEvent
+	events = append(events, touchFrame(0, 0, [2]int32{100, 100}, [2]int32{200, 100})...)
+	// Both fingers move 8mm down (one detent) and 8mm left
+	events = append(events, touchFrame(300*time.Millisecond, 2, [2]int32{20, 180}, [2]int32{120, 180})...)
+
+	want := [][]byte{{0, 0, 0, 1, 1}}
+	if got := collectReports(t, touchpad, events); !equalReports(got, want) {
+		t.Errorf("reports = %v, want %v", got, want)
+	}
+}
+
+func TestTouchpadRelay_Tap(t *testing.T) {
+	tests := []struct {
+		name    string
+		fingers [][2]int32
+		lift    time.Duration
+		click   bool
+		want    [][]byte
+	}{
+		{
+			name:    "one finger tap",
+			fingers: [][2]int32{{100, 100}},
+			lift:    100 * time.Millisecond,
+			want:    [][]byte{{0x01, 0, 0, 0, 0}, {0, 0, 0, 0, 0}},
+		},
+		{
+			name:    "two finger tap",
+			fingers: [][2]int32{{100, 100}, {200, 100}},
+			lift:    100 * time.Millisecond,
+			want:    [][]byte{{0x02, 0, 0, 0, 0}, {0, 0, 0, 0, 0}},
+		},
+		{
+			name:    "three finger tap",
+			fingers: [][2]int32{{100, 100}, {200, 100}, {300, 100}},
+			lift:    100 * time.Millisecond,
+			want:    [][]byte{{0x04, 0, 0, 0, 0}, {0, 0, 0, 0, 0}},
+		},
+		{
+			name:    "resting finger",
+			fingers: [][2]int32{{100, 100}},
+			lift:    time.Second,
+			want:    nil,
+		},
+		{
+			name:    "physical click",
+			fingers: [][2]int32{{100, 100}},
+			lift:    100 * time.Millisecond,
+			click:   true,
+			want:    [][]byte{{0x01, 0, 0, 0, 0}, {0, 0, 0, 0, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			touchpad := newTestTouchpad()
+
+			events := touchFrame(0, 0, tt.fingers...)
+			if tt.click {
+				events = append(events,
+					InputEvent{Type: 1, Code: 272, Value: 1}, synReport,
+					InputEvent{Type: 1, Code: 272, Value: 0}, synReport,
+				)
+			}
+			events = append(events, touchFrame(tt.lift, len(tt.fingers))...)
+
+			if got := collectReports(t, touchpad, events); !equalReports(got, tt.want) {
+				t.Errorf("reports = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func equalReports(got, want [][]byte) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			return false
+		}
+	}
+	return true
+}
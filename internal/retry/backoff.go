@@ -35,3 +35,9 @@ func (bt *BackoffTimer) NextDelay() time.Duration {
 
 	return baseDelay + time.Duration(jitter)
 }
+
+// Reset starts the delays over from the base delay, e.g. once an operation
+// succeeded again.
+func (bt *BackoffTimer) Reset() {
+	bt.attempts = 0
+}
@@ -62,3 +62,16 @@ func TestBackoffTimer(t *testing.T) {
 		})
 	}
 }
+
+func TestBackoffTimer_Reset(t *testing.T) {
+	timer := NewBackoffTimer(5, time.Second)
+	timer.NextDelay()
+	timer.NextDelay()
+	timer.Reset()
+
+	const allowedJitter = 0.2 // 20%
+	got := timer.NextDelay()
+	if difference := math.Abs(float64(got-time.Second)) / float64(time.Second); difference > allowedJitter {
+		t.Errorf("BackoffTimer after Reset = %v, want about %v", got, time.Second)
+	}
+}
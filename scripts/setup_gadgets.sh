@@ -7,6 +7,54 @@ if [[ $EUID -ne 0 ]]; then
    exit 1
 fi
 
+# Keyboard report layout: "boot" sends the standard 8-byte report (6-key rollover),
+# "nkro" appends a bitmap with one bit per key. Run the relay with -keyboard-nkro
+# when using "nkro".
+KEYBOARD_MODE=${KEYBOARD_MODE:-boot}
+if [ "$KEYBOARD_MODE" != "boot" ] && [ "$KEYBOARD_MODE" != "nkro" ]; then
+    echo "Unknown KEYBOARD_MODE: $KEYBOARD_MODE (expected boot or nkro)"
+    exit 1
+fi
+
+# Mouse report layout: "8bit" sends X/Y as signed bytes, "16bit" as signed 16-bit
+# values so fast movements of high-DPI mice fit into a single report. Run the relay
+# with -mouse-16bit when using "16bit".
+MOUSE_MODE=${MOUSE_MODE:-8bit}
+if [ "$MOUSE_MODE" != "8bit" ] && [ "$MOUSE_MODE" != "16bit" ]; then
+    echo "Unknown MOUSE_MODE: $MOUSE_MODE (expected 8bit or 16bit)"
+    exit 1
+fi
+
+# Mouse wheel resolution: "detent" sends one unit per wheel notch, "hires" adds a
+# Resolution Multiplier feature so hosts that enable it (Windows, Linux) get smooth
+# scrolling with 120 units per notch. Run the relay with -mouse-hires-wheel when
+# using "hires".
+MOUSE_WHEEL=${MOUSE_WHEEL:-detent}
+if [ "$MOUSE_WHEEL" != "detent" ] && [ "$MOUSE_WHEEL" != "hires" ]; then
+    echo "Unknown MOUSE_WHEEL: $MOUSE_WHEEL (expected detent or hires)"
+    exit 1
+fi
+
+# Pen tablet and touchscreen support: "on" adds a digitizer function (/dev/hidg3). It
+# is off by default because each function takes USB endpoints, of which boards like the
+# Pi Zero only have a few.
+DIGITIZER=${DIGITIZER:-off}
+if [ "$DIGITIZER" != "on" ] && [ "$DIGITIZER" != "off" ]; then
+    echo "Unknown DIGITIZER: $DIGITIZER (expected on or off)"
+    exit 1
+fi
+
+# Game controller support: "on" adds a gamepad function, off by default for the same
+# reason. The kernel numbers the hidg devices in the order they are created, so the
+# gamepad is /dev/hidg4 next to a digitizer and /dev/hidg3 without one.
+GAMEPAD=${GAMEPAD:-off}
+if [ "$GAMEPAD" != "on" ] && [ "$GAMEPAD" != "off" ]; then
+    echo "Unknown GAMEPAD: $GAMEPAD (expected on or off)"
+    exit 1
+fi
+GAMEPAD_DEVICE=/dev/hidg4
+[ "$DIGITIZER" = "off" ] && GAMEPAD_DEVICE=/dev/hidg3
+
 # check if modules are loaded
 MODULES_LOADED=0
 if lsmod | grep -E "g_ether|usb_f_rndis|usb_f_ecm|u_ether" > /dev/null; then
@@ -53,10 +101,16 @@ cleanup_gadget() {
         # Remove symbolic links
         rm -f configs/c.1/hid.usb0
         rm -f configs/c.1/hid.usb1
+        rm -f configs/c.1/hid.usb2
+        rm -f configs/c.1/hid.usb3
+        rm -f configs/c.1/hid.usb4
         
         # Remove directories
         rm -rf functions/hid.usb0
         rm -rf functions/hid.usb1
+        rm -rf functions/hid.usb2
+        rm -rf functions/hid.usb3
+        rm -rf functions/hid.usb4
         rm -rf configs/c.1/strings/0x409
         rm -rf configs/c.1
         rm -rf strings/0x409
@@ -64,6 +118,9 @@ cleanup_gadget() {
         cd ..
         rmdir hid_gadget 2>/dev/null || true
     fi
+
+    # The host sets the mouse's Resolution Multiplier again when it enumerates the new gadget
+    rm -f /run/bt-hid-relay/mouse-multipliers
 }
 
 # Only proceed with cleanup if user confirmed when needed
@@ -104,18 +161,99 @@ echo 250 > configs/c.1/MaxPower
 mkdir -p functions/hid.usb0
 echo 0 > functions/hid.usb0/protocol
 echo 0 > functions/hid.usb0/subclass
-echo 4 > functions/hid.usb0/report_length
-echo -ne \\x05\\x01\\x09\\x02\\xa1\\x01\\x09\\x01\\xa1\\x00\\x05\\x09\\x19\\x01\\x29\\x03\\x15\\x00\\x25\\x01\\x95\\x03\\x75\\x01\\x81\\x02\\x95\\x01\\x75\\x05\\x81\\x03\\x05\\x01\\x09\\x30\\x09\\x31\\x09\\x38\\x15\\x81\\x25\\x7f\\x75\\x08\\x95\\x03\\x81\\x06\\xc0\\xc0 > functions/hid.usb0/report_desc
+# The report descriptor is assembled from the parts selected by MOUSE_MODE and MOUSE_WHEEL
+# Mouse, pointer, 5 buttons and 3 bits of padding
+MOUSE_DESC='\x05\x01\x09\x02\xa1\x01\x09\x01\xa1\x00\x05\x09\x19\x01\x29\x05\x15\x00\x25\x01\x95\x05\x75\x01\x81\x02\x95\x01\x75\x03\x81\x03'
+if [ "$MOUSE_MODE" = "16bit" ]; then
+    # X and Y as 16-bit values (-32767 to 32767)
+    MOUSE_DESC+='\x05\x01\x09\x30\x09\x31\x16\x01\x80\x26\xff\x7f\x75\x10\x95\x02\x81\x06'
+    MOUSE_REPORT_LENGTH=7
+else
+    # X and Y as 8-bit values
+    MOUSE_DESC+='\x05\x01\x09\x30\x09\x31\x15\x81\x25\x7f\x75\x08\x95\x02\x81\x06'
+    MOUSE_REPORT_LENGTH=5
+fi
+if [ "$MOUSE_WHEEL" = "hires" ]; then
+    # Wheel and AC Pan (horizontal wheel) each in a logical collection with a 2-bit
+    # Resolution Multiplier (physical 1 to 120), sharing one feature report byte
+    MOUSE_DESC+='\xa1\x02\x05\x01\x09\x48\x15\x00\x25\x01\x35\x01\x45\x78\x75\x02\x95\x01\xb1\x02\x09\x38\x15\x81\x25\x7f\x35\x00\x45\x00\x75\x08\x95\x01\x81\x06\xc0'
+    MOUSE_DESC+='\xa1\x02\x05\x01\x09\x48\x15\x00\x25\x01\x35\x01\x45\x78\x75\x02\x95\x01\xb1\x02\x35\x00\x45\x00\x75\x04\xb1\x01\x05\x0c\x0a\x38\x02\x15\x81\x25\x7f\x75\x08\x95\x01\x81\x06\xc0'
+else
+    # Wheel and AC Pan (horizontal wheel) as 8-bit values
+    MOUSE_DESC+='\x09\x38\x15\x81\x25\x7f\x75\x08\x95\x01\x81\x06\x05\x0c\x0a\x38\x02\x15\x81\x25\x7f\x75\x08\x95\x01\x81\x06'
+fi
+MOUSE_DESC+='\xc0\xc0'
+echo $MOUSE_REPORT_LENGTH > functions/hid.usb0/report_length
+if [ "$MOUSE_WHEEL" = "hires" ]; then
+    # The multiplier is set with a SET_REPORT request, which the kernel only passes on
+    # to the relay when the function has no OUT endpoint
+    if [ -f functions/hid.usb0/no_out_endpoint ]; then
+        echo 1 > functions/hid.usb0/no_out_endpoint
+    else
+        echo "Warning: kernel can't pass the resolution multiplier to the relay, scrolling will use detents"
+    fi
+fi
+echo -ne "$MOUSE_DESC" > functions/hid.usb0/report_desc
 
 # Set up Keyboard HID function
+# Both layouts declare a 1-byte LED output report (Num/Caps/Scroll Lock, Compose, Kana)
+# that the relay mirrors back to the Bluetooth keyboard.
+# The boot layout's key array accepts every usage up to 0xE7, so keypad, F13-F24 and
+# international keys (including ones forwarded from the keyboard's scan codes) reach the host
 mkdir -p functions/hid.usb1
 echo 1 > functions/hid.usb1/protocol
 echo 1 > functions/hid.usb1/subclass
-echo 8 > functions/hid.usb1/report_length
-echo -ne \\x05\\x01\\x09\\x06\\xa1\\x01\\x05\\x07\\x19\\xe0\\x29\\xe7\\x15\\x00\\x25\\x01\\x75\\x01\\x95\\x08\\x81\\x02\\x95\\x01\\x75\\x08\\x81\\x03\\x95\\x06\\x75\\x08\\x15\\x00\\x25\\x65\\x05\\x07\\x19\\x00\\x29\\x65\\x81\\x00\\xc0 > functions/hid.usb1/report_desc
+if [ "$KEYBOARD_MODE" = "nkro" ]; then
+    # The first 8 bytes keep the boot report layout so a BIOS using the boot protocol
+    # still works; the descriptor marks bytes 1-7 as padding and declares a bitmap for
+    # usages 0x00-0xDF (28 bytes) after them, which report protocol hosts use instead.
+    echo 36 > functions/hid.usb1/report_length
+    echo -ne \\x05\\x01\\x09\\x06\\xa1\\x01\\x05\\x07\\x19\\xe0\\x29\\xe7\\x15\\x00\\x25\\x01\\x75\\x01\\x95\\x08\\x81\\x02\\x95\\x07\\x75\\x08\\x81\\x01\\x95\\x05\\x75\\x01\\x05\\x08\\x19\\x01\\x29\\x05\\x91\\x02\\x95\\x01\\x75\\x03\\x91\\x01\\x05\\x07\\x19\\x00\\x29\\xdf\\x15\\x00\\x25\\x01\\x75\\x01\\x96\\xe0\\x00\\x81\\x02\\xc0 > functions/hid.usb1/report_desc
+else
+    echo 8 > functions/hid.usb1/report_length
+    echo -ne \\x05\\x01\\x09\\x06\\xa1\\x01\\x05\\x07\\x19\\xe0\\x29\\xe7\\x15\\x00\\x25\\x01\\x75\\x01\\x95\\x08\\x81\\x02\\x95\\x01\\x75\\x08\\x81\\x03\\x95\\x05\\x75\\x01\\x05\\x08\\x19\\x01\\x29\\x05\\x91\\x02\\x95\\x01\\x75\\x03\\x91\\x01\\x95\\x06\\x75\\x08\\x15\\x00\\x26\\xe7\\x00\\x05\\x07\\x19\\x00\\x29\\xe7\\x81\\x00\\xc0 > functions/hid.usb1/report_desc
+fi
+
+# Set up Consumer Control (media keys) and System Control (power/sleep/wake) HID function
+# Report ID 1 carries a single 16-bit Consumer page usage
+# Report ID 2 carries System Power Down, System Sleep and System Wake Up bits
+mkdir -p functions/hid.usb2
+echo 0 > functions/hid.usb2/protocol
+echo 0 > functions/hid.usb2/subclass
+echo 3 > functions/hid.usb2/report_length
+# The host never sends reports to this function, so skip the OUT endpoint where the kernel allows it
+[ -f functions/hid.usb2/no_out_endpoint ] && echo 1 > functions/hid.usb2/no_out_endpoint
+echo -ne \\x05\\x0c\\x09\\x01\\xa1\\x01\\x85\\x01\\x15\\x00\\x26\\xff\\x03\\x19\\x00\\x2a\\xff\\x03\\x75\\x10\\x95\\x01\\x81\\x00\\xc0\\x05\\x01\\x09\\x80\\xa1\\x01\\x85\\x02\\x19\\x81\\x29\\x83\\x15\\x00\\x25\\x01\\x75\\x01\\x95\\x03\\x81\\x02\\x95\\x05\\x81\\x01\\xc0 > functions/hid.usb2/report_desc
+
+# Set up Digitizer (pen tablet, touchscreen) HID function
+# Tip Switch, Barrel Switch, Eraser, Invert, Secondary Barrel Switch and In Range bits,
+# 2 bits of padding, X and Y (0 to 32767) and Tip Pressure (0 to 4095) as 16-bit values
+if [ "$DIGITIZER" = "on" ]; then
+    mkdir -p functions/hid.usb3
+    echo 0 > functions/hid.usb3/protocol
+    echo 0 > functions/hid.usb3/subclass
+    echo 7 > functions/hid.usb3/report_length
+    [ -f functions/hid.usb3/no_out_endpoint ] && echo 1 > functions/hid.usb3/no_out_endpoint
+    echo -ne \\x05\\x0d\\x09\\x02\\xa1\\x01\\x09\\x20\\xa1\\x00\\x09\\x42\\x09\\x44\\x09\\x45\\x09\\x3c\\x09\\x5a\\x09\\x32\\x15\\x00\\x25\\x01\\x75\\x01\\x95\\x06\\x81\\x02\\x95\\x02\\x81\\x03\\x05\\x01\\x09\\x30\\x09\\x31\\x26\\xff\\x7f\\x75\\x10\\x95\\x02\\x81\\x02\\x05\\x0d\\x09\\x30\\x26\\xff\\x0f\\x95\\x01\\x81\\x02\\xc0\\xc0 > functions/hid.usb3/report_desc
+fi
+
+# Set up Gamepad HID function
+# 16 buttons, a hat switch (0-7 clockwise from north, null state when centered) with
+# 4 bits of padding, and X, Y, Z, Rx, Ry and Rz as 16-bit values (-32767 to 32767)
+if [ "$GAMEPAD" = "on" ]; then
+    mkdir -p functions/hid.usb4
+    echo 0 > functions/hid.usb4/protocol
+    echo 0 > functions/hid.usb4/subclass
+    echo 15 > functions/hid.usb4/report_length
+    [ -f functions/hid.usb4/no_out_endpoint ] && echo 1 > functions/hid.usb4/no_out_endpoint
+    echo -ne \\x05\\x01\\x09\\x05\\xa1\\x01\\x05\\x09\\x19\\x01\\x29\\x10\\x15\\x00\\x25\\x01\\x75\\x01\\x95\\x10\\x81\\x02\\x05\\x01\\x09\\x39\\x25\\x07\\x35\\x00\\x46\\x3b\\x01\\x65\\x14\\x75\\x04\\x95\\x01\\x81\\x42\\x81\\x03\\x45\\x00\\x65\\x00\\x09\\x30\\x09\\x31\\x09\\x32\\x09\\x33\\x09\\x34\\x09\\x35\\x16\\x01\\x80\\x26\\xff\\x7f\\x75\\x10\\x95\\x06\\x81\\x02\\xc0 > functions/hid.usb4/report_desc
+fi
 
 ln -s functions/hid.usb0 configs/c.1/
 ln -s functions/hid.usb1 configs/c.1/
+ln -s functions/hid.usb2 configs/c.1/
+[ "$DIGITIZER" = "on" ] && ln -s functions/hid.usb3 configs/c.1/
+[ "$GAMEPAD" = "on" ] && ln -s functions/hid.usb4 configs/c.1/
 
 # Enable gadget
 UDC=$(ls /sys/class/udc)
@@ -141,4 +279,32 @@ else
     exit 1
 fi
 
+if [ -e /dev/hidg2 ]; then
+    echo "HID device consumer/system control /dev/hidg2 created successfully."
+else
+    echo "Error: HID device consumer/system control /dev/hidg2 not created."
+    exit 1
+fi
+
+if [ "$DIGITIZER" = "on" ]; then
+    if [ -e /dev/hidg3 ]; then
+        echo "HID device digitizer /dev/hidg3 created successfully."
+    else
+        echo "Error: HID device digitizer /dev/hidg3 not created."
+        exit 1
+    fi
+fi
+
+if [ "$GAMEPAD" = "on" ]; then
+    if [ -e $GAMEPAD_DEVICE ]; then
+        echo "HID device gamepad $GAMEPAD_DEVICE created successfully."
+    else
+        echo "Error: HID device gamepad $GAMEPAD_DEVICE not created."
+        exit 1
+    fi
+    if [ "$DIGITIZER" = "off" ]; then
+        echo "Run the relay with -digitizer-output '' -gamepad-output $GAMEPAD_DEVICE to match."
+    fi
+fi
+
 echo "Setup completed successfully."
\ No newline at end of file
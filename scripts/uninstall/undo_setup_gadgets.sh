@@ -23,10 +23,16 @@
         # Remove symbolic links
         rm -f configs/c.1/hid.usb0
         rm -f configs/c.1/hid.usb1
+        rm -f configs/c.1/hid.usb2
+        rm -f configs/c.1/hid.usb3
+        rm -f configs/c.1/hid.usb4
         
         # Remove directories
         rm -rf functions/hid.usb0
         rm -rf functions/hid.usb1
+        rm -rf functions/hid.usb2
+        rm -rf functions/hid.usb3
+        rm -rf functions/hid.usb4
         rm -rf configs/c.1/strings/0x409
         rm -rf configs/c.1
         rm -rf strings/0x409